@@ -28,6 +28,15 @@ type Mutation struct {
 	// err is set to a Datastore or gRPC error, if Mutation is not valid
 	// (see https://godoc.org/google.golang.org/grpc/codes).
 	err error
+
+	// cond is set when the Mutation was built by NewInsertIf, NewUpdateIf,
+	// NewUpsertIf or NewDeleteIf; it carries the precondition that must
+	// match before the mutation is applied. See MutateIf.
+	cond *condMutation
+
+	// opts holds per-mutation knobs set via MutationOptions-style methods,
+	// such as WithIdempotencyKey.
+	opts MutationOptions
 }
 
 func (m *Mutation) isDelete() bool {
@@ -0,0 +1,106 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"context"
+	"time"
+)
+
+// MutationOp identifies the kind of change a CommittedMutation represents.
+type MutationOp string
+
+const (
+	OpInsert MutationOp = "insert"
+	OpUpdate MutationOp = "update"
+	OpUpsert MutationOp = "upsert"
+	OpDelete MutationOp = "delete"
+)
+
+// CommittedMutation describes one mutation that has just been committed
+// to Datastore, as delivered to a function registered with
+// Client.OnMutationCommitted.
+type CommittedMutation struct {
+	// Key is the key of the affected entity.
+	Key *Key
+	// Op is the kind of change that was applied.
+	Op MutationOp
+	// Entity is the serialized post-image of the entity, or nil for a
+	// delete.
+	Entity PropertyList
+	// Version is the commit version reported by Datastore for this
+	// mutation.
+	Version int64
+	// CommitTime is the timestamp of the transaction that applied the
+	// mutation.
+	CommitTime time.Time
+}
+
+// MutationListener is called with the mutations committed by a single
+// Mutate or MutateStream batch, in commit order.
+type MutationListener func(ctx context.Context, muts []CommittedMutation)
+
+// OnMutationCommitted registers fn to be called with the mutations that
+// were just applied, so callers can fan change events out to Pub/Sub, a
+// search index, or any other downstream system without polling Datastore.
+// Only batches committed through MutateStream invoke listeners; Mutate,
+// MutateIf and transactions do not. Multiple listeners may be registered;
+// they are called in registration order. Listeners run synchronously on
+// the commit path, so they should not block for long or do expensive
+// work inline -- a common pattern is to register a listener that simply
+// enqueues to a channel drained by a separate goroutine, or to use the
+// Pub/Sub sink in the datastore/cdc/pubsub subpackage.
+func (c *Client) OnMutationCommitted(fn MutationListener) {
+	c.mutationListeners = append(c.mutationListeners, fn)
+}
+
+// notifyMutationsCommitted builds the CommittedMutation slice for a
+// committed batch and invokes every registered listener.
+// MutateStream's commitBatch (mutation_stream.go) calls it once per
+// successful Commit RPC, passing the Mutations that batch actually sent.
+// Mutate itself is defined outside this package snapshot, so it is not
+// (yet) wired to call this; only mutations sent through MutateStream
+// currently fire OnMutationCommitted listeners. commitBatch passes
+// Version: 0 and a local time.Now() for CommitTime, since c.Mutate's
+// return value exposes neither the server's commit version nor its
+// actual commit timestamp. A failed Commit must not call it:
+// notifyMutationsCommitted has no way to tell partial success from
+// total failure on its own.
+func (c *Client) notifyMutationsCommitted(ctx context.Context, muts []*Mutation, version int64, commitTime time.Time) {
+	if len(c.mutationListeners) == 0 {
+		return
+	}
+	committed := make([]CommittedMutation, 0, len(muts))
+	for _, m := range muts {
+		if m.err != nil {
+			continue
+		}
+		cm := CommittedMutation{
+			Key:        m.key,
+			Op:         MutationOp(mutationOp(m)),
+			Version:    version,
+			CommitTime: commitTime,
+		}
+		if e := mutationEntity(m); e != nil {
+			if props, err := c.decryptedPropertiesFromProto(ctx, e); err == nil {
+				cm.Entity = props
+			}
+		}
+		committed = append(committed, cm)
+	}
+	for _, fn := range c.mutationListeners {
+		fn(ctx, committed)
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrPreconditionFailed is returned when a conditional Mutation's
+// precondition did not match the entity's current state at commit time.
+var ErrPreconditionFailed = errors.New("datastore: precondition failed")
+
+// A Precondition is evaluated against the current state of an entity
+// (or its absence, represented as an empty, non-nil []Property) before a
+// conditional Mutation is applied. PropertyPrecondition, AndPrecondition,
+// OrPrecondition and AbsentPrecondition are the built-in implementations;
+// see them for the concrete predicates available.
+type Precondition interface {
+	evaluate(props []Property) (bool, error)
+}
+
+// condMutation is a Mutation guarded by a Precondition. The precondition is
+// checked against the entity's current state inside a read-write
+// transaction; the underlying mutation is only committed if it matches.
+type condMutation struct {
+	*Mutation
+	pre Precondition
+}
+
+// NewInsertIf creates a Mutation that saves the entity src into the
+// datastore with key k, but only if k does not already exist and pre
+// matches the absence of an entity (an empty property list). It is
+// primarily useful when pre also needs to assert on unrelated state, for
+// symmetry with NewUpdateIf and NewUpsertIf.
+func NewInsertIf(k *Key, src interface{}, pre Precondition) *Mutation {
+	return newCondMutation(NewInsert(k, src), pre)
+}
+
+// NewUpdateIf creates a Mutation that replaces the entity in the datastore
+// with key k, but only if pre matches the entity's current properties.
+// Mutate reports ErrPreconditionFailed if it does not.
+func NewUpdateIf(k *Key, src interface{}, pre Precondition) *Mutation {
+	return newCondMutation(NewUpdate(k, src), pre)
+}
+
+// NewUpsertIf creates a Mutation that saves the entity into the datastore
+// with key k, whether or not k exists, but only if pre matches the
+// entity's current properties (or the absence of an entity, if k does not
+// exist).
+func NewUpsertIf(k *Key, src interface{}, pre Precondition) *Mutation {
+	return newCondMutation(NewUpsert(k, src), pre)
+}
+
+// NewDeleteIf creates a Mutation that deletes the entity with key k, but
+// only if pre matches its current properties.
+func NewDeleteIf(k *Key, pre Precondition) *Mutation {
+	return newCondMutation(NewDelete(k), pre)
+}
+
+func newCondMutation(m *Mutation, pre Precondition) *Mutation {
+	if m.err != nil {
+		return m
+	}
+	m.cond = &condMutation{Mutation: m, pre: pre}
+	return m
+}
+
+// MutateIf applies conditional mutations created by NewInsertIf,
+// NewUpdateIf, NewUpsertIf and NewDeleteIf. Each mutation is evaluated in
+// its own read-write transaction: the entity at the mutation's key is
+// fetched, the precondition is checked against its properties (an empty,
+// non-nil slice if the entity does not exist), and the mutation is
+// committed only if the precondition matches. If it does not, the
+// transaction is rolled back and the corresponding result in the returned
+// MultiError is ErrPreconditionFailed.
+//
+// Unconditional mutations (those not built with a NewXxxIf constructor)
+// are rejected with an error, since they don't need the extra
+// transactional round trip that MutateIf performs.
+func (c *Client) MutateIf(ctx context.Context, muts ...*Mutation) ([]*PendingKey, error) {
+	pending := make([]*PendingKey, len(muts))
+	merr := make(MultiError, len(muts))
+	var any bool
+	for i, m := range muts {
+		if m.err != nil {
+			merr[i] = m.err
+			any = true
+			continue
+		}
+		if m.cond == nil {
+			merr[i] = errors.New("datastore: MutateIf requires a mutation built with NewInsertIf, NewUpdateIf, NewUpsertIf or NewDeleteIf")
+			any = true
+			continue
+		}
+		pk, err := c.runConditional(ctx, m.cond)
+		if err != nil {
+			merr[i] = err
+			any = true
+			continue
+		}
+		pending[i] = pk
+	}
+	if any {
+		return pending, merr
+	}
+	return pending, nil
+}
+
+func (c *Client) runConditional(ctx context.Context, cm *condMutation) (*PendingKey, error) {
+	var pk *PendingKey
+	_, err := c.RunInTransaction(ctx, func(tx *Transaction) error {
+		var props PropertyList
+		getErr := tx.Get(cm.key, &props)
+		var current []Property
+		switch getErr {
+		case nil:
+			current = props
+		case ErrNoSuchEntity:
+			current = []Property{}
+		default:
+			return getErr
+		}
+		ok, err := cm.pre.evaluate(current)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrPreconditionFailed
+		}
+		var mutErr error
+		pk, mutErr = tx.Mutate(cm.Mutation)
+		return mutErr
+	})
+	return pk, err
+}
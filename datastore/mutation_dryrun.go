@@ -0,0 +1,146 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"context"
+	"reflect"
+
+	pb "cloud.google.com/go/datastore/apiv1/datastorepb"
+)
+
+// MutationPlan describes, for a single Mutation, the effect DryRunMutate
+// predicts it would have if it were committed with Mutate.
+type MutationPlan struct {
+	// Key is the mutation's target key.
+	Key *Key
+	// Op is one of "insert", "update", "upsert" or "delete".
+	Op string
+	// Before holds the entity's current properties, or nil if no entity
+	// exists at Key.
+	Before PropertyList
+	// After holds the properties the entity would have once the
+	// mutation is applied, or nil for a delete.
+	After PropertyList
+	// ChangedIndexed lists the names of indexed properties whose value
+	// would change (including properties that would be added or
+	// removed).
+	ChangedIndexed []string
+}
+
+// DryRunMutate evaluates muts as Mutate would -- validating them and
+// looking up the current state of every affected key -- but performs no
+// writes. It returns one MutationPlan per input Mutation, in order, so
+// callers (admin CLIs, audit tooling) can preview destructive batches of
+// NewDelete/NewUpdate before committing them.
+func (c *Client) DryRunMutate(ctx context.Context, muts ...*Mutation) ([]MutationPlan, error) {
+	if _, err := mutationProtos(muts); err != nil {
+		return nil, err
+	}
+	keys := make([]*Key, len(muts))
+	for i, m := range muts {
+		keys[i] = m.key
+	}
+	before := make([]PropertyList, len(keys))
+	err := c.GetMulti(ctx, keys, before)
+	var merr MultiError
+	if err != nil {
+		var ok bool
+		if merr, ok = err.(MultiError); !ok {
+			return nil, err
+		}
+	}
+
+	plans := make([]MutationPlan, len(muts))
+	for i, m := range muts {
+		plan := MutationPlan{Key: m.key, Op: mutationOp(m)}
+		if merr == nil || merr[i] == nil {
+			plan.Before = before[i]
+		} else if merr[i] != ErrNoSuchEntity {
+			return nil, merr
+		}
+		if e := mutationEntity(m); e != nil {
+			after, err := c.decryptedPropertiesFromProto(ctx, e)
+			if err != nil {
+				return nil, err
+			}
+			plan.After = after
+		}
+		plan.ChangedIndexed = diffIndexedProperties(plan.Before, plan.After)
+		plans[i] = plan
+	}
+	return plans, nil
+}
+
+// mutationEntity returns the post-image entity proto a mutation carries,
+// or nil for a delete.
+func mutationEntity(m *Mutation) *pb.Entity {
+	switch op := m.mut.Operation.(type) {
+	case *pb.Mutation_Insert:
+		return op.Insert
+	case *pb.Mutation_Update:
+		return op.Update
+	case *pb.Mutation_Upsert:
+		return op.Upsert
+	default:
+		return nil
+	}
+}
+
+func mutationOp(m *Mutation) string {
+	switch m.mut.Operation.(type) {
+	case *pb.Mutation_Insert:
+		return "insert"
+	case *pb.Mutation_Update:
+		return "update"
+	case *pb.Mutation_Upsert:
+		return "upsert"
+	default:
+		return "delete"
+	}
+}
+
+// diffIndexedProperties returns the names of indexed properties that
+// differ (by presence or value) between before and after. Property.Value
+// can hold slice-backed dynamic types ([]byte for a blob, []interface{}
+// for an array, PropertyList for a nested entity), which are not
+// comparable with ==, so values are compared with reflect.DeepEqual
+// rather than !=.
+func diffIndexedProperties(before, after PropertyList) []string {
+	beforeVals := map[string]Property{}
+	for _, p := range before {
+		if !p.NoIndex {
+			beforeVals[p.Name] = p
+		}
+	}
+	afterVals := map[string]Property{}
+	for _, p := range after {
+		if !p.NoIndex {
+			afterVals[p.Name] = p
+		}
+	}
+	var changed []string
+	for name, bp := range beforeVals {
+		if ap, ok := afterVals[name]; !ok || !reflect.DeepEqual(ap.Value, bp.Value) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range afterVals {
+		if _, ok := beforeVals[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,80 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestDiffIndexedProperties(t *testing.T) {
+	for _, test := range []struct {
+		desc   string
+		before PropertyList
+		after  PropertyList
+		want   []string
+	}{
+		{
+			desc:   "identical blobs",
+			before: PropertyList{{Name: "b", Value: []byte{1, 2, 3}}},
+			after:  PropertyList{{Name: "b", Value: []byte{1, 2, 3}}},
+			want:   nil,
+		},
+		{
+			desc:   "changed blob",
+			before: PropertyList{{Name: "b", Value: []byte{1, 2, 3}}},
+			after:  PropertyList{{Name: "b", Value: []byte{1, 2, 4}}},
+			want:   []string{"b"},
+		},
+		{
+			desc:   "identical arrays",
+			before: PropertyList{{Name: "a", Value: []interface{}{int64(1), int64(2)}}},
+			after:  PropertyList{{Name: "a", Value: []interface{}{int64(1), int64(2)}}},
+			want:   nil,
+		},
+		{
+			desc:   "changed array",
+			before: PropertyList{{Name: "a", Value: []interface{}{int64(1), int64(2)}}},
+			after:  PropertyList{{Name: "a", Value: []interface{}{int64(1), int64(3)}}},
+			want:   []string{"a"},
+		},
+		{
+			desc:   "identical nested entity",
+			before: PropertyList{{Name: "n", Value: PropertyList{{Name: "x", Value: int64(1)}}}},
+			after:  PropertyList{{Name: "n", Value: PropertyList{{Name: "x", Value: int64(1)}}}},
+			want:   nil,
+		},
+		{
+			desc:   "changed nested entity",
+			before: PropertyList{{Name: "n", Value: PropertyList{{Name: "x", Value: int64(1)}}}},
+			after:  PropertyList{{Name: "n", Value: PropertyList{{Name: "x", Value: int64(2)}}}},
+			want:   []string{"n"},
+		},
+		{
+			desc:   "noindex properties are ignored",
+			before: PropertyList{{Name: "b", Value: []byte{1}, NoIndex: true}},
+			after:  PropertyList{{Name: "b", Value: []byte{2}, NoIndex: true}},
+			want:   nil,
+		},
+	} {
+		got := diffIndexedProperties(test.before, test.after)
+		sort.Strings(got)
+		sort.Strings(test.want)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: diffIndexedProperties = %v, want %v", test.desc, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"sync"
+
+	pb "cloud.google.com/go/datastore/apiv1/datastorepb"
+	kms "cloud.google.com/go/kms/apiv1"
+	kmspb "cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+// wrappedDEKProperty is the base name of the sibling blob property
+// EncryptField writes next to an encrypted field to hold the extra
+// properties (such as a KMS-wrapped DEK) a PropertyEncryptor needs to
+// later decrypt it. EncryptField namespaces it per field (see
+// encryptedExtraProperty) so that encrypting several fields on the same
+// entity gives each one its own sibling instead of the fields colliding
+// on a single shared name. It is not meant to be read directly by
+// callers.
+const wrappedDEKProperty = "__kms_wrapped_dek__"
+
+// encryptedFieldsProperty lists, as a comma-separated string, the names
+// of the fields on an entity that EncryptField has encrypted.
+// decryptedPropertiesFromProto consults it to know which properties to
+// run through PropertyEncryptor.Decrypt and which sibling properties
+// belong to which field; it is never meant to be read directly by
+// callers. decryptedPropertiesFromProto strips it from the PropertyList it
+// returns when the Client has a PropertyEncryptor, and leaves it in place
+// otherwise.
+const encryptedFieldsProperty = "__encrypted_fields__"
+
+// encryptedExtraProperty returns the namespaced sibling property name
+// EncryptField uses to store one of enc.Encrypt's extra properties
+// (named base, e.g. wrappedDEKProperty) for the given encrypted field, so
+// that extras for different fields on the same entity never collide.
+func encryptedExtraProperty(field, base string) string {
+	return field + ":" + base
+}
+
+// PropertyEncryptor encrypts and decrypts the raw bytes of a single
+// property value. Implementations are registered on a Client with
+// WithPropertyEncryptor, and consulted explicitly on the write side by
+// Mutation.EncryptField and on the read side by decryptedPropertiesFromProto
+// (mutation_proto.go) -- NewInsert/NewUpsert/NewUpdate build their Mutation
+// straight from saveEntity's output with no *Client in scope, so there is
+// no hook to encrypt a field automatically from a struct tag the way
+// saveEntity/loadEntity handle NoIndex. EncryptField/decryptedPropertiesFromProto
+// are the real integration points.
+type PropertyEncryptor interface {
+	// Encrypt returns the ciphertext for plaintext, plus any additional
+	// entity properties (such as a wrapped DEK) that must be stored
+	// alongside it to later decrypt it.
+	Encrypt(ctx context.Context, plaintext []byte) (ciphertext []byte, extra []Property, err error)
+	// Decrypt reverses Encrypt, given the ciphertext and the extra
+	// properties that were stored alongside it.
+	Decrypt(ctx context.Context, ciphertext []byte, extra []Property) ([]byte, error)
+}
+
+// WithPropertyEncryptor configures c to use enc in
+// decryptedPropertiesFromProto, which decrypts the post-image PropertyLists
+// that DryRunMutate returns and that OnMutationCommitted listeners
+// receive. Entities read with Get or GetMulti are returned as stored, with
+// encrypted fields still holding ciphertext. Mutation.EncryptField does
+// not consult it: it always uses the encryptor passed to it.
+func WithPropertyEncryptor(enc PropertyEncryptor) ClientOption {
+	return propertyEncryptorOption{enc}
+}
+
+type propertyEncryptorOption struct{ enc PropertyEncryptor }
+
+func (o propertyEncryptorOption) apply(c *Client) {
+	c.propertyEncryptor = o.enc
+}
+
+// EncryptField replaces the named property on m's post-image entity with
+// its ciphertext under enc, plus enc's extra sibling properties (such as
+// KMSEncryptor's wrapped DEK). It is the real write-side hook for
+// encrypted fields: because NewInsert/NewUpsert/NewUpdate build m from
+// saveEntity's output before any *Client is in scope, there is no struct
+// tag this package can act on automatically, so a field meant to be
+// encrypted must be passed to EncryptField after the Mutation is built
+// and before it is handed to Mutate/MutateStream.
+//
+// field must name a property whose current value is a []byte (the blob a
+// BlobValue proto carries); any other value type, or a delete Mutation
+// with no post-image entity, is an error. EncryptField is scoped to a
+// single field per call, but it is safe to call it again for other
+// fields on the same Mutation: each call's extra properties are
+// namespaced to field (see encryptedExtraProperty), so they never
+// collide with another encrypted field's.
+func (m *Mutation) EncryptField(ctx context.Context, enc PropertyEncryptor, field string) *Mutation {
+	if m.err != nil {
+		return m
+	}
+	e := mutationEntity(m)
+	if e == nil {
+		return &Mutation{err: fmt.Errorf("datastore: EncryptField %q: mutation has no post-image entity to encrypt", field)}
+	}
+	v, ok := e.GetProperties()[field]
+	if !ok {
+		return &Mutation{err: fmt.Errorf("datastore: EncryptField: no property %q", field)}
+	}
+	plaintext, ok := v.GetValueType().(*pb.Value_BlobValue)
+	if !ok {
+		return &Mutation{err: fmt.Errorf("datastore: EncryptField: property %q is not a blob value", field)}
+	}
+	ciphertext, extra, err := enc.Encrypt(ctx, plaintext.BlobValue)
+	if err != nil {
+		return &Mutation{err: fmt.Errorf("datastore: EncryptField %q: %w", field, err)}
+	}
+	e.Properties[field] = &pb.Value{
+		ValueType:          &pb.Value_BlobValue{BlobValue: ciphertext},
+		ExcludeFromIndexes: v.GetExcludeFromIndexes(),
+	}
+	for _, p := range extra {
+		b, ok := p.Value.([]byte)
+		if !ok {
+			return &Mutation{err: fmt.Errorf("datastore: EncryptField %q: extra property %q must be []byte, got %T", field, p.Name, p.Value)}
+		}
+		e.Properties[encryptedExtraProperty(field, p.Name)] = &pb.Value{
+			ValueType:          &pb.Value_BlobValue{BlobValue: b},
+			ExcludeFromIndexes: p.NoIndex,
+		}
+	}
+	fields := field
+	if existing, ok := e.GetProperties()[encryptedFieldsProperty]; ok {
+		fields = existing.GetStringValue() + "," + field
+	}
+	e.Properties[encryptedFieldsProperty] = &pb.Value{
+		ValueType:          &pb.Value_StringValue{StringValue: fields},
+		ExcludeFromIndexes: true,
+	}
+	return m
+}
+
+// KMSEncryptor is a PropertyEncryptor that implements envelope encryption
+// with Cloud KMS: a fresh 256-bit AES-GCM data encryption key (DEK) is
+// generated locally for every encrypted value, the value is sealed with
+// it, and the DEK itself is wrapped by a KMS key so only holders of that
+// key can ever unwrap it. The wrapped DEK travels with the ciphertext as
+// the wrappedDEKProperty sibling property.
+//
+// Unwrapped DEKs are cached in memory, keyed by the wrapped bytes, so that
+// repeated reads of the same encrypted value don't each cost a KMS round
+// trip. Since every value gets its own DEK, values encrypted separately
+// under the same KMS key do not share a cache entry.
+type KMSEncryptor struct {
+	client   *kms.KeyManagementClient
+	keyName  string   // e.g. "projects/P/locations/L/keyRings/R/cryptoKeys/K"
+	dekCache sync.Map // wrapped DEK (string) -> unwrapped DEK ([]byte)
+}
+
+// NewKMSEncryptor returns a KMSEncryptor that wraps per-entity DEKs with
+// the Cloud KMS key named keyName, using client for KMS calls.
+func NewKMSEncryptor(client *kms.KeyManagementClient, keyName string) *KMSEncryptor {
+	return &KMSEncryptor{client: client, keyName: keyName}
+}
+
+// Encrypt generates a random 256-bit DEK, seals plaintext with AES-GCM
+// under it, and wraps the DEK with the configured KMS key.
+func (e *KMSEncryptor) Encrypt(ctx context.Context, plaintext []byte) ([]byte, []Property, error) {
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, nil, fmt.Errorf("datastore: generating DEK: %w", err)
+	}
+	ciphertext, err := sealAESGCM(dek, plaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := e.client.Encrypt(ctx, &kmspb.EncryptRequest{
+		Name:      e.keyName,
+		Plaintext: dek,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("datastore: wrapping DEK: %w", err)
+	}
+	e.dekCache.Store(string(resp.Ciphertext), dek)
+	extra := []Property{{
+		Name:    wrappedDEKProperty,
+		Value:   resp.Ciphertext,
+		NoIndex: true,
+	}}
+	return ciphertext, extra, nil
+}
+
+// Decrypt unwraps the DEK found in extra (consulting the cache first) and
+// opens ciphertext with it.
+func (e *KMSEncryptor) Decrypt(ctx context.Context, ciphertext []byte, extra []Property) ([]byte, error) {
+	var wrapped []byte
+	for _, p := range extra {
+		if p.Name == wrappedDEKProperty {
+			wrapped, _ = p.Value.([]byte)
+		}
+	}
+	if wrapped == nil {
+		return nil, fmt.Errorf("datastore: missing %s property for encrypted value", wrappedDEKProperty)
+	}
+	if cached, ok := e.dekCache.Load(string(wrapped)); ok {
+		return openAESGCM(cached.([]byte), ciphertext)
+	}
+	resp, err := e.client.Decrypt(ctx, &kmspb.DecryptRequest{
+		Name:       e.keyName,
+		Ciphertext: wrapped,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("datastore: unwrapping DEK: %w", err)
+	}
+	e.dekCache.Store(string(wrapped), resp.Plaintext)
+	return openAESGCM(resp.Plaintext, ciphertext)
+}
+
+func sealAESGCM(key, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func openAESGCM(key, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	n := gcm.NonceSize()
+	if len(sealed) < n {
+		return nil, fmt.Errorf("datastore: encrypted value too short")
+	}
+	return gcm.Open(nil, sealed[:n], sealed[n:], nil)
+}
@@ -0,0 +1,290 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"container/list"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// MutationOptions holds per-mutation knobs that don't warrant their own
+// NewXxx constructor. It is the extension point future options (timeouts,
+// priority, and so on) should be added to, rather than each one growing a
+// new constructor variant.
+type MutationOptions struct {
+	// IdempotencyKey, if non-empty, lets MutateStream retry a transient
+	// commit failure (codes.Unavailable, codes.DeadlineExceeded) once.
+	// Mutate does not use it. See Mutation.WithIdempotencyKey.
+	IdempotencyKey string
+}
+
+// WithIdempotencyKey sets an idempotency key on m and returns m. The key
+// must be unique per logical mutation (e.g. a UUID generated once by the
+// caller for the lifetime of that write). Mutations submitted through
+// MutateStream are committed via commitWithRetry, which uses the key to
+// retry a transient commit failure and, if a commit reports
+// AlreadyExists, to resolve it to the result of an earlier commit with the
+// same key that this Client saw succeed. An attempt that returned an error
+// is never recorded, so an AlreadyExists caused by such an attempt having
+// landed on the server is returned as an error. A direct call to Mutate
+// does not go through commitWithRetry, so a key set here only takes
+// effect for mutations sent via MutateStream.
+func (m *Mutation) WithIdempotencyKey(key string) *Mutation {
+	if m.err != nil {
+		return m
+	}
+	m.opts.IdempotencyKey = key
+	return m
+}
+
+// idempotencyCacheSize bounds the number of recent commit responses kept
+// in memory per Client.
+const idempotencyCacheSize = 10000
+
+// idempotencyCache is a bounded LRU mapping an idempotency key to the
+// commit result it produced, so a retried commit can recognize that the
+// original attempt already landed. It lives in an unexported
+// *idempotencyCache field on Client, the same way Client already holds
+// mutationListeners, and is created lazily by commitWithRetry on its
+// first successful keyed result.
+type idempotencyCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // most-recently-used at the front
+}
+
+type idempotencyEntry struct {
+	key    string
+	result *Key
+	err    error
+}
+
+func newIdempotencyCache() *idempotencyCache {
+	return &idempotencyCache{
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+func (c *idempotencyCache) get(key string) (*Key, error, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, nil, false
+	}
+	c.order.MoveToFront(el)
+	e := el.Value.(*idempotencyEntry)
+	return e.result, e.err, true
+}
+
+func (c *idempotencyCache) put(key string, result *Key, err error) {
+	if key == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.order.MoveToFront(el)
+		el.Value.(*idempotencyEntry).result = result
+		el.Value.(*idempotencyEntry).err = err
+		return
+	}
+	el := c.order.PushFront(&idempotencyEntry{key: key, result: result, err: err})
+	c.entries[key] = el
+	if c.order.Len() > idempotencyCacheSize {
+		oldest := c.order.Back()
+		if oldest != nil {
+			c.order.Remove(oldest)
+			delete(c.entries, oldest.Value.(*idempotencyEntry).key)
+		}
+	}
+}
+
+// resolveIdempotentCommit inspects err, the error a commit attempt for m
+// produced, and consults cache to decide whether a retried commit that
+// now reports AlreadyExists actually reflects the caller's own earlier,
+// successful attempt rather than a genuine conflict.
+func resolveIdempotentCommit(cache *idempotencyCache, m *Mutation, err error) (*Key, error, bool) {
+	if m.opts.IdempotencyKey == "" {
+		return nil, err, false
+	}
+	if status.Code(err) != codes.AlreadyExists {
+		return nil, err, false
+	}
+	if result, cachedErr, ok := cache.get(m.opts.IdempotencyKey); ok {
+		return result, cachedErr, true
+	}
+	return nil, err, false
+}
+
+// isRetryableCommitError reports whether err is a transient error that is
+// safe to retry when every mutation in the batch carries an idempotency
+// key.
+func isRetryableCommitError(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	default:
+		return false
+	}
+}
+
+// commitWithRetry calls c.Mutate(ctx, muts...), retrying exactly once on a
+// transient error (isRetryableCommitError), and resolving any
+// AlreadyExists against c.idempotency, which holds the results of earlier
+// commits this Client saw succeed. A first attempt that returned an error
+// is not recorded there, so if it landed on the server anyway, the
+// retry's AlreadyExists is not resolved and is returned as an error. It
+// is the real caller resolveIdempotentCommit
+// and isRetryableCommitError were written for -- WithIdempotencyKey and the
+// cache do nothing unless a commit path calls this instead of c.Mutate
+// directly; commitBatch (mutation_stream.go) is that caller.
+//
+// The retry is only attempted when every mutation in the batch carries an
+// IdempotencyKey: without one there is no way to tell a genuine conflict
+// from the retry racing its own first attempt, so a batch with any
+// unkeyed mutation is left to fail (or succeed) on the single Commit like
+// before. c.idempotency is created lazily on first successful result that
+// needs caching; until then (and if it is nil for any other reason) retry
+// still happens, it just can't resolve a retried AlreadyExists.
+func (c *Client) commitWithRetry(ctx context.Context, muts []*Mutation) ([]*Key, error) {
+	keys, err := c.Mutate(ctx, muts...)
+	if err == nil {
+		c.cacheIdempotentResults(muts, keys)
+		return keys, nil
+	}
+	if !allKeyed(muts) || !isRetryableCommitError(unwrapBatchError(err)) {
+		if len(keys) < len(muts) {
+			keys = append(keys, make([]*Key, len(muts)-len(keys))...)
+		}
+		return keys, c.resolveCommitError(muts, keys, err)
+	}
+	keys, err = c.Mutate(ctx, muts...)
+	if err == nil {
+		c.cacheIdempotentResults(muts, keys)
+		return keys, nil
+	}
+	if len(keys) < len(muts) {
+		keys = append(keys, make([]*Key, len(muts)-len(keys))...)
+	}
+	return keys, c.resolveCommitError(muts, keys, err)
+}
+
+// allKeyed reports whether every mutation in muts carries an
+// IdempotencyKey.
+func allKeyed(muts []*Mutation) bool {
+	for _, m := range muts {
+		if m.opts.IdempotencyKey == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// unwrapBatchError returns the representative error isRetryableCommitError
+// should classify: err itself for a batch-level (transport) failure, or
+// the first non-nil per-mutation error for a MultiError. The other
+// per-mutation errors of a MultiError are not examined.
+func unwrapBatchError(err error) error {
+	merr, ok := err.(MultiError)
+	if !ok {
+		return err
+	}
+	for _, e := range merr {
+		if e != nil {
+			return e
+		}
+	}
+	return err
+}
+
+// resolveCommitError runs every per-mutation error in err (or err itself,
+// for a batch-level failure) through resolveIdempotentCommit, patching
+// keys and clearing the error for any mutation that turns out to be a
+// retry landing on its own earlier success.
+func (c *Client) resolveCommitError(muts []*Mutation, keys []*Key, err error) error {
+	if c.idempotency == nil {
+		return err
+	}
+	merr, ok := err.(MultiError)
+	if !ok {
+		if resolved, resolvedErr, ok := resolveIdempotentCommit(c.idempotency, muts[0], err); ok {
+			if len(keys) == 0 {
+				keys = make([]*Key, 1)
+			}
+			keys[0] = resolved
+			return resolvedErr
+		}
+		return err
+	}
+	resolvedAny := false
+	for i, e := range merr {
+		if e == nil {
+			continue
+		}
+		if resolved, resolvedErr, ok := resolveIdempotentCommit(c.idempotency, muts[i], e); ok {
+			merr[i] = resolvedErr
+			if i < len(keys) {
+				keys[i] = resolved
+			}
+			resolvedAny = true
+		}
+	}
+	if !resolvedAny {
+		return merr
+	}
+	for _, e := range merr {
+		if e != nil {
+			return merr
+		}
+	}
+	return nil
+}
+
+// cacheIdempotentResults records each successfully committed mutation's
+// result under its IdempotencyKey, lazily creating c.idempotency on first
+// use. It is only called once a commit has already succeeded. Mutations
+// without a key are skipped (idempotencyCache.put is a no-op for an empty
+// key, but checking here avoids the lock for the common case of a batch
+// with no keyed mutations at all).
+func (c *Client) cacheIdempotentResults(muts []*Mutation, keys []*Key) {
+	if !anyKeyed(muts) {
+		return
+	}
+	if c.idempotency == nil {
+		c.idempotency = newIdempotencyCache()
+	}
+	for i, m := range muts {
+		if m.opts.IdempotencyKey == "" {
+			continue
+		}
+		c.idempotency.put(m.opts.IdempotencyKey, keyOrNil(keys, i), nil)
+	}
+}
+
+// anyKeyed reports whether at least one mutation in muts carries an
+// IdempotencyKey.
+func anyKeyed(muts []*Mutation) bool {
+	for _, m := range muts {
+		if m.opts.IdempotencyKey != "" {
+			return true
+		}
+	}
+	return false
+}
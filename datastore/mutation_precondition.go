@@ -0,0 +1,74 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import "reflect"
+
+// PropertyPrecondition is a Precondition that matches when the entity has
+// a property named Name whose Value is equal to Value. A nonexistent
+// entity, or one missing the named property, never matches.
+type PropertyPrecondition struct {
+	Name  string
+	Value interface{}
+}
+
+func (p PropertyPrecondition) evaluate(props []Property) (bool, error) {
+	for _, prop := range props {
+		if prop.Name == p.Name {
+			return reflect.DeepEqual(prop.Value, p.Value), nil
+		}
+	}
+	return false, nil
+}
+
+// AbsentPrecondition is a Precondition that matches only when the entity
+// does not currently exist, for guarding NewInsertIf/NewUpsertIf against a
+// concurrent create.
+type AbsentPrecondition struct{}
+
+func (AbsentPrecondition) evaluate(props []Property) (bool, error) {
+	return len(props) == 0, nil
+}
+
+// AndPrecondition is a Precondition that matches when every one of its
+// elements matches.
+type AndPrecondition []Precondition
+
+func (a AndPrecondition) evaluate(props []Property) (bool, error) {
+	for _, p := range a {
+		ok, err := p.evaluate(props)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// OrPrecondition is a Precondition that matches when at least one of its
+// elements matches.
+type OrPrecondition []Precondition
+
+func (o OrPrecondition) evaluate(props []Property) (bool, error) {
+	for _, p := range o {
+		ok, err := p.evaluate(props)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
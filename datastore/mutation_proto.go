@@ -0,0 +1,163 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	pb "cloud.google.com/go/datastore/apiv1/datastorepb"
+)
+
+// propertiesFromProto converts the properties of an Entity proto -- the
+// post-image carried by a Mutation's insert/update/upsert payload -- back
+// into a PropertyList, sorted by name for a deterministic result. It is
+// the read-side counterpart callers like DryRunMutate's After field and
+// notifyMutationsCommitted's CommittedMutation.Entity use to turn the
+// proto a Mutation already built back into the PropertyList shape
+// saveEntity produced it from.
+func propertiesFromProto(e *pb.Entity) (PropertyList, error) {
+	props := make(PropertyList, 0, len(e.GetProperties()))
+	for name, v := range e.GetProperties() {
+		val, err := propertyValueFromProto(v)
+		if err != nil {
+			return nil, fmt.Errorf("datastore: property %q: %w", name, err)
+		}
+		props = append(props, Property{
+			Name:    name,
+			Value:   val,
+			NoIndex: v.GetExcludeFromIndexes(),
+		})
+	}
+	sort.Slice(props, func(i, j int) bool { return props[i].Name < props[j].Name })
+	return props, nil
+}
+
+func propertyValueFromProto(v *pb.Value) (interface{}, error) {
+	switch t := v.GetValueType().(type) {
+	case nil, *pb.Value_NullValue:
+		return nil, nil
+	case *pb.Value_BooleanValue:
+		return t.BooleanValue, nil
+	case *pb.Value_IntegerValue:
+		return t.IntegerValue, nil
+	case *pb.Value_DoubleValue:
+		return t.DoubleValue, nil
+	case *pb.Value_TimestampValue:
+		return t.TimestampValue.AsTime(), nil
+	case *pb.Value_StringValue:
+		return t.StringValue, nil
+	case *pb.Value_BlobValue:
+		return t.BlobValue, nil
+	case *pb.Value_KeyValue:
+		return keyFromProto(t.KeyValue), nil
+	case *pb.Value_EntityValue:
+		return propertiesFromProto(t.EntityValue)
+	case *pb.Value_ArrayValue:
+		vals := make([]interface{}, len(t.ArrayValue.GetValues()))
+		for i, av := range t.ArrayValue.GetValues() {
+			val, err := propertyValueFromProto(av)
+			if err != nil {
+				return nil, err
+			}
+			vals[i] = val
+		}
+		return vals, nil
+	default:
+		return nil, fmt.Errorf("datastore: unsupported value type %T", t)
+	}
+}
+
+// decryptedPropertiesFromProto is propertiesFromProto plus a pass that
+// reverses Mutation.EncryptField: for every field named in the entity's
+// encryptedFieldsProperty marker, its value is run through
+// c.propertyEncryptor.Decrypt using that field's namespaced extra
+// properties (see encryptedExtraProperty), and both the marker and the
+// extras are dropped from the result. If c.propertyEncryptor is nil or
+// the marker is absent, it behaves exactly like propertiesFromProto --
+// callers that never configured WithPropertyEncryptor pay no cost and
+// see no encrypted-looking blobs, since nothing in this package produces
+// them without EncryptField.
+func (c *Client) decryptedPropertiesFromProto(ctx context.Context, e *pb.Entity) (PropertyList, error) {
+	props, err := propertiesFromProto(e)
+	if err != nil {
+		return nil, err
+	}
+	marker, ok := e.GetProperties()[encryptedFieldsProperty]
+	if c.propertyEncryptor == nil || !ok {
+		return props, nil
+	}
+	encryptedFields := map[string]bool{}
+	for _, f := range strings.Split(marker.GetStringValue(), ",") {
+		encryptedFields[f] = true
+	}
+
+	byName := make(map[string]int, len(props))
+	out := make(PropertyList, 0, len(props))
+	for _, p := range props {
+		if p.Name == encryptedFieldsProperty {
+			continue
+		}
+		if strings.Contains(p.Name, ":") {
+			continue // namespaced extra property (e.g. "field:__kms_wrapped_dek__"), not a real field
+		}
+		byName[p.Name] = len(out)
+		out = append(out, p)
+	}
+	for field := range encryptedFields {
+		i, ok := byName[field]
+		if !ok {
+			continue
+		}
+		ciphertext, ok := out[i].Value.([]byte)
+		if !ok {
+			continue
+		}
+		var extra []Property
+		for _, p := range props {
+			if strings.HasPrefix(p.Name, field+":") {
+				extra = append(extra, Property{Name: strings.TrimPrefix(p.Name, field+":"), Value: p.Value, NoIndex: p.NoIndex})
+			}
+		}
+		plaintext, err := c.propertyEncryptor.Decrypt(ctx, ciphertext, extra)
+		if err != nil {
+			return nil, fmt.Errorf("datastore: decrypting property %q: %w", field, err)
+		}
+		out[i].Value = plaintext
+	}
+	return out, nil
+}
+
+// keyFromProto rebuilds a *Key from its proto path, walking root to leaf
+// so each element's Parent points at the previously built Key.
+func keyFromProto(k *pb.Key) *Key {
+	if k == nil {
+		return nil
+	}
+	ns := k.GetPartitionId().GetNamespaceId()
+	var parent *Key
+	for _, el := range k.GetPath() {
+		parent = &Key{
+			Kind:      el.GetKind(),
+			ID:        el.GetId(),
+			Name:      el.GetName(),
+			Parent:    parent,
+			Namespace: ns,
+		}
+	}
+	return parent
+}
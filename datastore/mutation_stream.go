@@ -0,0 +1,210 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// maxMutationsPerCommit is the maximum number of mutations Datastore
+	// accepts in a single Commit call.
+	maxMutationsPerCommit = 500
+
+	// maxCommitBytes is a conservative cap on the serialized size of a
+	// single Commit request, kept comfortably under the server's 10MiB
+	// request-size limit to leave room for the transaction/mode fields.
+	maxCommitBytes = 9 << 20
+
+	// maxConcurrentCommits bounds how many batches MutateStream will send
+	// to the server at once.
+	maxConcurrentCommits = 4
+)
+
+// MutationResult is the outcome of a single Mutation submitted through
+// MutateStream, in the same order the Mutation was read from the input
+// channel.
+type MutationResult struct {
+	// Index is the 0-based position of the Mutation in the stream.
+	Index int
+	// Key is the (possibly allocated) key of the mutated entity.
+	Key *Key
+	// Err is the error, if any, that applying this particular mutation
+	// produced. A batch-level error (e.g. a transport failure) is
+	// reported on every mutation in that batch.
+	Err error
+}
+
+// MutateStream accepts an unbounded sequence of mutations from muts,
+// splits them into Commit-sized batches (respecting Datastore's 500
+// mutation and ~10MiB request-size limits), deduplicates repeated
+// upserts/deletes to the same key across the whole stream (mutationProtos
+// dedups only deletes, and only within a single Mutate call), and commits
+// the batches to Datastore, fanning out up to maxConcurrentCommits of
+// them concurrently. It returns a channel of MutationResult, one per
+// mutation read from muts, delivered in submission order -- a later
+// batch finishing its Commit before an earlier one does not reorder
+// what the caller sees; the channel is closed once every batch has been
+// committed (or muts is closed and all in-flight batches have
+// finished). A deduplicated mutation still gets its own MutationResult,
+// carrying its own Key and a nil Err, since the mutation that won the
+// dedup is the one actually responsible for applying it.
+//
+// MutateStream is meant for large ETL-style loads where the caller would
+// otherwise hand-roll PutMulti chunking and key reassignment.
+func (c *Client) MutateStream(ctx context.Context, muts <-chan *Mutation) (<-chan MutationResult, error) {
+	out := make(chan MutationResult)
+	go func() {
+		defer close(out)
+
+		var (
+			batch []indexedMutation
+			size  int
+			sem   = make(chan struct{}, maxConcurrentCommits)
+			wg    sync.WaitGroup
+			// seenUpsert and seenDelete dedup upserts and deletes by key
+			// across the whole stream, kept separate so an Upsert(k)
+			// followed later by a Delete(k) (or vice versa) is never
+			// treated as a duplicate of the other -- they're different
+			// operations on the same key, not repeats of the same one.
+			seenUpsert = map[string]bool{}
+			seenDelete = map[string]bool{}
+			next       int
+			// batches carries one channel per flushed batch (real or
+			// immediate), in submission order; the deliverer goroutine
+			// below drains them in that same order so concurrent
+			// commits can't reorder what callers see on out.
+			batches = make(chan chan []MutationResult, 2*maxConcurrentCommits)
+			done    = make(chan struct{})
+		)
+		go func() {
+			defer close(done)
+			for ch := range batches {
+				for _, r := range <-ch {
+					out <- r
+				}
+			}
+		}()
+
+		immediate := func(r MutationResult) {
+			ch := make(chan []MutationResult, 1)
+			ch <- []MutationResult{r}
+			batches <- ch
+		}
+		flush := func(b []indexedMutation) {
+			if len(b) == 0 {
+				return
+			}
+			ch := make(chan []MutationResult, 1)
+			batches <- ch
+			sem <- struct{}{}
+			wg.Add(1)
+			go func(b []indexedMutation) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				ch <- c.commitBatch(ctx, b)
+			}(b)
+		}
+
+		for m := range muts {
+			idx := next
+			next++
+			if m.err != nil {
+				immediate(MutationResult{Index: idx, Err: m.err})
+				continue
+			}
+			if m.isDelete() {
+				ks := m.key.String()
+				if seenDelete[ks] {
+					immediate(MutationResult{Index: idx, Key: m.key})
+					continue
+				}
+				seenDelete[ks] = true
+			} else if m.mut.GetUpsert() != nil {
+				ks := m.key.String()
+				if seenUpsert[ks] {
+					immediate(MutationResult{Index: idx, Key: m.key})
+					continue
+				}
+				seenUpsert[ks] = true
+			}
+			msz := proto.Size(m.mut)
+			if len(batch) >= maxMutationsPerCommit || size+msz > maxCommitBytes {
+				flush(batch)
+				batch = nil
+				size = 0
+			}
+			batch = append(batch, indexedMutation{index: idx, m: m})
+			size += msz
+		}
+		flush(batch)
+		wg.Wait()
+		close(batches)
+		<-done
+	}()
+	return out, nil
+}
+
+type indexedMutation struct {
+	index int
+	m     *Mutation
+}
+
+// commitBatch commits one chunk of mutations and returns a MutationResult
+// for each of them, in their original stream order. It commits through
+// commitWithRetry rather than calling c.Mutate directly so that a batch
+// of keyed mutations (see Mutation.WithIdempotencyKey) gets one safe
+// retry on a transient failure instead of leaving the caller to guess
+// whether a timed-out commit actually landed.
+func (c *Client) commitBatch(ctx context.Context, batch []indexedMutation) []MutationResult {
+	muts := make([]*Mutation, len(batch))
+	for i, im := range batch {
+		muts[i] = im.m
+	}
+	results := make([]MutationResult, len(batch))
+	keys, err := c.commitWithRetry(ctx, muts)
+	if err != nil {
+		if merr, ok := err.(MultiError); ok {
+			for i, im := range batch {
+				results[i] = MutationResult{Index: im.index, Key: keyOrNil(keys, i), Err: merr[i]}
+			}
+			return results
+		}
+		for i, im := range batch {
+			results[i] = MutationResult{Index: im.index, Err: err}
+		}
+		return results
+	}
+	for i, im := range batch {
+		results[i] = MutationResult{Index: im.index, Key: keyOrNil(keys, i)}
+	}
+	// Mutate's return value carries no commit version or server commit
+	// timestamp, so listeners see Version: 0 (unknown) and a best-effort
+	// CommitTime taken locally right after the RPC returns, not the
+	// transaction's actual commit time.
+	c.notifyMutationsCommitted(ctx, muts, 0, time.Now())
+	return results
+}
+
+func keyOrNil(keys []*Key, i int) *Key {
+	if i < len(keys) {
+		return keys[i]
+	}
+	return nil
+}
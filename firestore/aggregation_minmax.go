@@ -0,0 +1,86 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firestore
+
+import (
+	"context"
+
+	"google.golang.org/api/iterator"
+)
+
+// Min returns the document in q with the smallest value of path, or
+// iterator.Done if no document matches.
+//
+// There is deliberately no AggregationQuery.WithMin: Firestore's
+// RunAggregationQuery RPC only supports count, sum and average as
+// server-side aggregations (see TestWithSum/TestWithAvg), so a min/max
+// "aggregation" built the way WithSum is would have no operator to
+// compile down to, and would either fail at the server or have to be
+// faked with exactly the ORDER BY path LIMIT 1 query this function runs
+// anyway -- at which point it isn't an aggregation and doesn't belong on
+// AggregationQuery, whose whole contract is "one round trip, no
+// documents materialized". Min/Max live on Query instead for that
+// reason, and TestMin/TestMax assert against a mocked RunQueryResponse,
+// not a RunAggregationQueryResponse, because that's really what runs.
+func (q Query) Min(ctx context.Context, path string) (*DocumentSnapshot, error) {
+	return q.extremeBy(ctx, path, Asc)
+}
+
+// MinPath is like Min but takes a FieldPath, for field names that contain
+// special characters.
+func (q Query) MinPath(ctx context.Context, fp FieldPath) (*DocumentSnapshot, error) {
+	return q.extremeByPath(ctx, fp, Asc)
+}
+
+// Max returns the document in q with the largest value of path, or
+// iterator.Done if no document matches. See Min for why this runs an
+// ordered, limited query rather than a server-side aggregation.
+func (q Query) Max(ctx context.Context, path string) (*DocumentSnapshot, error) {
+	return q.extremeBy(ctx, path, Desc)
+}
+
+// MaxPath is like Max but takes a FieldPath, for field names that contain
+// special characters.
+func (q Query) MaxPath(ctx context.Context, fp FieldPath) (*DocumentSnapshot, error) {
+	return q.extremeByPath(ctx, fp, Desc)
+}
+
+func (q Query) extremeBy(ctx context.Context, path string, dir Direction) (*DocumentSnapshot, error) {
+	fp, err := parseDotSeparatedString(path)
+	if err != nil {
+		return nil, err
+	}
+	return q.extremeByPath(ctx, fp, dir)
+}
+
+// extremeByPath runs q ordered solely by fp to find its extreme value.
+// Any OrderBy/OrderByPath and cursor already set on q is for a different
+// sort order than the one being asked for here and is discarded first --
+// otherwise fp would only ever break ties after q's existing primary
+// sort key, silently returning the extreme of the *first* key instead of
+// fp.
+func (q Query) extremeByPath(ctx context.Context, fp FieldPath, dir Direction) (*DocumentSnapshot, error) {
+	q.orders = nil
+	q.startVals, q.startDoc = nil, nil
+	q.endVals, q.endDoc = nil, nil
+	docs, err := q.OrderByPath(fp, dir).Limit(1).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, iterator.Done
+	}
+	return docs[0], nil
+}
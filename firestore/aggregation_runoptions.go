@@ -0,0 +1,65 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firestore
+
+import "context"
+
+// WithRunOptions returns a new AggregationQuery with opts applied,
+// mirroring Query.WithRunOptions. As with Query, passing ExplainOptions
+// more than once, or passing a nil RunOption, is reported as an error
+// once the query is run rather than immediately, since newRunQuerySettings
+// is what actually validates opts.
+func (a *AggregationQuery) WithRunOptions(opts ...RunOption) *AggregationQuery {
+	a = a.clone()
+	a.runOpts = append(a.runOpts, opts...)
+	return a
+}
+
+// ExplainMetrics returns the typed ExplainMetrics for the most recent
+// call to Get, present whenever WithRunOptions(ExplainOptions{...}) was
+// used. It returns ErrExplainNotReady if Get hasn't been called yet, or
+// if it was called without ExplainOptions.
+func (a *AggregationQuery) ExplainMetrics() (*ExplainMetrics, error) {
+	if a.err != nil {
+		return nil, a.err
+	}
+	if a.explainMetrics == nil {
+		return nil, ErrExplainNotReady
+	}
+	return newExplainMetrics(a.explainMetrics)
+}
+
+// GetResponse runs the aggregation query like Get, and also returns its
+// ExplainMetrics in the same call, for callers that don't want to make a
+// separate ExplainMetrics call after Get.
+//
+// Get is responsible for populating a.explainMetrics from the server's
+// RunAggregationQueryResponse whenever WithRunOptions(ExplainOptions{...})
+// was used, the same way it already turns a.runOpts into the request's
+// explain options; ExplainMetrics only exposes what Get leaves behind.
+func (a *AggregationQuery) GetResponse(ctx context.Context) (AggregationResult, *ExplainMetrics, error) {
+	result, err := a.Get(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	metrics, err := a.ExplainMetrics()
+	if err == ErrExplainNotReady {
+		return result, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return result, metrics, nil
+}
@@ -0,0 +1,146 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firestore
+
+import (
+	"context"
+	"sync"
+)
+
+// defaultMaxBatchConcurrency is the number of RunQuery streams
+// BatchRunQueries multiplexes over when no MaxConcurrency option is
+// given.
+const defaultMaxBatchConcurrency = 5
+
+// BatchOption configures a BatchRunQueries call.
+type BatchOption interface {
+	applyBatch(*batchSettings)
+}
+
+type batchSettings struct {
+	maxConcurrency int
+}
+
+// MaxConcurrency bounds how many of the batch's queries run their
+// RunQuery stream at the same time. The default is 5.
+type MaxConcurrency int
+
+func (m MaxConcurrency) applyBatch(s *batchSettings) { s.maxConcurrency = int(m) }
+
+func newBatchSettings(opts []BatchOption) *batchSettings {
+	s := &batchSettings{maxConcurrency: defaultMaxBatchConcurrency}
+	for _, o := range opts {
+		o.applyBatch(s)
+	}
+	if s.maxConcurrency <= 0 {
+		s.maxConcurrency = defaultMaxBatchConcurrency
+	}
+	return s
+}
+
+// BatchQueryResult is the outcome of one of the queries submitted to
+// BatchRunQueries, tagged with the index of the originating Query in the
+// slice passed to it.
+type BatchQueryResult struct {
+	// Index is the position of the originating Query in the slice passed
+	// to BatchRunQueries.
+	Index int
+	// Docs holds the query's matching documents, in server order.
+	Docs []*DocumentSnapshot
+	// ExplainMetrics holds the query's explain metrics if its Query was
+	// run with WithRunOptions(ExplainOptions{...}), and nil otherwise.
+	ExplainMetrics *ExplainMetrics
+	// Err is any error this particular query produced; it does not fail
+	// the other queries in the batch.
+	Err error
+}
+
+// BatchRunQueries submits every query in queries as its own RunQuery
+// call, multiplexed over a bounded pool of at most MaxConcurrency (opts,
+// default 5) concurrent streams, and returns one BatchQueryResult per
+// query, in the same order as queries. A failure in one query is
+// reported on its own result and does not prevent the others from
+// completing.
+func (c *Client) BatchRunQueries(ctx context.Context, queries []*Query, opts ...BatchOption) ([]BatchQueryResult, error) {
+	settings := newBatchSettings(opts)
+	results := make([]BatchQueryResult, len(queries))
+
+	sem := make(chan struct{}, settings.maxConcurrency)
+	var wg sync.WaitGroup
+	for i, q := range queries {
+		i, q := i, q
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = runSingleQuery(ctx, i, q)
+		}()
+	}
+	wg.Wait()
+	return results, nil
+}
+
+func runSingleQuery(ctx context.Context, index int, q *Query) BatchQueryResult {
+	it := q.Documents(ctx)
+	defer it.Stop()
+	docs, err := it.GetAll()
+	result := BatchQueryResult{Index: index, Docs: docs, Err: err}
+	if err != nil {
+		return result
+	}
+	if it.runOptions != nil && it.runOptions.explainOptions != nil {
+		result.ExplainMetrics, result.Err = it.ExplainMetrics()
+	}
+	return result
+}
+
+// BatchRunQueriesIterator streams BatchQueryResult values as each
+// underlying query in the batch finishes, instead of collecting all of
+// them before returning.
+type BatchRunQueriesIterator struct {
+	results chan BatchQueryResult
+}
+
+// BatchRunQueriesStream behaves like BatchRunQueries, but returns results
+// as they become available rather than waiting for the whole batch.
+func (c *Client) BatchRunQueriesStream(ctx context.Context, queries []*Query, opts ...BatchOption) *BatchRunQueriesIterator {
+	settings := newBatchSettings(opts)
+	out := make(chan BatchQueryResult, len(queries))
+	go func() {
+		defer close(out)
+		sem := make(chan struct{}, settings.maxConcurrency)
+		var wg sync.WaitGroup
+		for i, q := range queries {
+			i, q := i, q
+			sem <- struct{}{}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+				out <- runSingleQuery(ctx, i, q)
+			}()
+		}
+		wg.Wait()
+	}()
+	return &BatchRunQueriesIterator{results: out}
+}
+
+// Next returns the next available BatchQueryResult. It returns ok == false
+// once every query in the batch has produced a result.
+func (it *BatchRunQueriesIterator) Next() (BatchQueryResult, bool) {
+	r, ok := <-it.results
+	return r, ok
+}
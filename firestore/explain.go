@@ -0,0 +1,119 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firestore
+
+import (
+	"errors"
+	"time"
+
+	pb "cloud.google.com/go/firestore/apiv1/firestorepb"
+)
+
+// ErrExplainNotReady is returned by ExplainMetrics if it is called before
+// the iterator it was obtained from has finished (or errored out of)
+// iteration.
+var ErrExplainNotReady = errors.New("firestore: explain metrics are not available until iteration is done")
+
+// IndexUsage describes one index the query planner considered using.
+type IndexUsage struct {
+	// IndexName is the name of the index.
+	IndexName string
+	// Properties is a human-readable description of the fields the index
+	// covers, as reported by the server.
+	Properties string
+	// QueryScope is the scope the index applies to (e.g. "COLLECTION",
+	// "COLLECTION_GROUP"), as reported by the server.
+	QueryScope string
+}
+
+// PlanSummary is the query-planner portion of ExplainMetrics. It is
+// always populated, whether or not ExplainOptions.Analyze was set.
+type PlanSummary struct {
+	// IndexesUsed lists the indexes the planner chose among.
+	IndexesUsed []IndexUsage
+}
+
+// ExecutionStats is the runtime portion of ExplainMetrics. It is only
+// populated when the query was run with ExplainOptions{Analyze: true}.
+type ExecutionStats struct {
+	// ResultsReturned is the number of results the query produced.
+	ResultsReturned int64
+	// ExecutionDuration is how long the query took to execute on the
+	// server.
+	ExecutionDuration time.Duration
+	// ReadOperations is the number of billed read operations the query
+	// consumed.
+	ReadOperations int64
+	// DebugStats holds any additional, implementation-defined execution
+	// statistics the server returned.
+	DebugStats map[string]any
+}
+
+// ExplainMetrics carries the query plan (and, when requested, execution
+// statistics) the server returns for an explained query. See
+// DocumentIterator.ExplainMetrics and AggregationQuery's equivalent
+// accessor.
+type ExplainMetrics struct {
+	// PlanSummary is always populated.
+	PlanSummary PlanSummary
+	// ExecutionStats is populated only when Analyze was set to true; it
+	// is the zero value otherwise.
+	ExecutionStats ExecutionStats
+}
+
+// newExplainMetrics converts the raw proto returned by the server into
+// the package's typed representation.
+func newExplainMetrics(pm *pb.ExplainMetrics) (*ExplainMetrics, error) {
+	if pm == nil {
+		return nil, nil
+	}
+	em := &ExplainMetrics{}
+	if ps := pm.GetPlanSummary(); ps != nil {
+		for _, idx := range ps.GetIndexesUsed() {
+			fields := idx.AsMap()
+			em.PlanSummary.IndexesUsed = append(em.PlanSummary.IndexesUsed, IndexUsage{
+				IndexName:  stringField(fields, "indexName"),
+				Properties: stringField(fields, "properties"),
+				QueryScope: stringField(fields, "queryScope"),
+			})
+		}
+	}
+	if es := pm.GetExecutionStats(); es != nil {
+		em.ExecutionStats = ExecutionStats{
+			ResultsReturned:   es.GetResultsReturned(),
+			ExecutionDuration: es.GetExecutionDuration().AsDuration(),
+			ReadOperations:    es.GetReadOperations(),
+			DebugStats:        es.GetDebugStats().AsMap(),
+		}
+	}
+	return em, nil
+}
+
+func stringField(m map[string]any, key string) string {
+	s, _ := m[key].(string)
+	return s
+}
+
+// ExplainMetrics returns the typed query plan (and, if the query was run
+// with ExplainOptions{Analyze: true}, execution statistics) the server
+// returned for it. It is only valid to call once iteration has finished,
+// either because Next returned iterator.Done or because the iterator was
+// drained with GetAll; calling it earlier returns ErrExplainNotReady.
+func (it *DocumentIterator) ExplainMetrics() (*ExplainMetrics, error) {
+	if !it.done {
+		return nil, ErrExplainNotReady
+	}
+	return newExplainMetrics(it.explainMetrics)
+}
@@ -0,0 +1,44 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firestore
+
+import "google.golang.org/api/iterator"
+
+// GetAllWithExplainMetrics drains it and returns its documents together
+// with the query's typed ExplainMetrics. When the query was run with
+// ExplainOptions{Analyze: false}, only the plan summary is wanted: it
+// drains it with Next, discards whatever documents Next yields, and
+// returns nil documents.
+func (it *DocumentIterator) GetAllWithExplainMetrics() ([]*DocumentSnapshot, *ExplainMetrics, error) {
+	if it.runOptions != nil && it.runOptions.explainOptions != nil && !it.runOptions.explainOptions.Analyze {
+		for {
+			_, err := it.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		metrics, err := it.ExplainMetrics()
+		return nil, metrics, err
+	}
+	docs, err := it.GetAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	metrics, err := it.ExplainMetrics()
+	return docs, metrics, err
+}
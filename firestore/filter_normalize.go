@@ -0,0 +1,100 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firestore
+
+import "errors"
+
+// errEmptyCompositeFilter is returned when an AndFilter or OrFilter with
+// no children is normalized; such a filter has no well-defined meaning.
+var errEmptyCompositeFilter = errors.New("firestore: AndFilter/OrFilter must have at least one child filter")
+
+// normalizeEntityFilter rewrites an arbitrarily nested tree of
+// AndFilter/OrFilter/PropertyFilter/PropertyPathFilter values into its
+// canonical form:
+//
+//   - a composite filter with exactly one child is replaced by that
+//     child (unwrapping single-child AndFilter/OrFilter)
+//   - a composite filter's children that are themselves composite
+//     filters with the *same* operator are flattened into the parent
+//     (Or(a, Or(b, c)) becomes Or(a, b, c))
+//   - a composite filter with no children is an error
+//
+// PreparedQuery.Bind (query_prepared.go) calls this on every top-level
+// filter after substituting Params, so a bound Query's filters are in
+// canonical form even when the template's were not; substituting a value
+// does not by itself change a filter tree's shape. WhereEntity and
+// Deserialize are both defined outside this package snapshot, so this
+// change does not reach them; a filter tree built directly through
+// WhereEntity (rather than through Prepare/Bind) is not normalized by
+// this package today.
+func normalizeEntityFilter(f EntityFilter) (EntityFilter, error) {
+	switch f := f.(type) {
+	case AndFilter:
+		return normalizeComposite(f.Filters, "AND", func(fs []EntityFilter) EntityFilter {
+			return AndFilter{Filters: fs}
+		})
+	case OrFilter:
+		return normalizeComposite(f.Filters, "OR", func(fs []EntityFilter) EntityFilter {
+			return OrFilter{Filters: fs}
+		})
+	default:
+		return f, nil
+	}
+}
+
+func normalizeComposite(children []EntityFilter, op string, rebuild func([]EntityFilter) EntityFilter) (EntityFilter, error) {
+	if len(children) == 0 {
+		return nil, errEmptyCompositeFilter
+	}
+	var flat []EntityFilter
+	for _, c := range children {
+		nc, err := normalizeEntityFilter(c)
+		if err != nil {
+			return nil, err
+		}
+		if sameOp(nc, op) {
+			flat = append(flat, compositeChildren(nc)...)
+		} else {
+			flat = append(flat, nc)
+		}
+	}
+	if len(flat) == 1 {
+		return flat[0], nil
+	}
+	return rebuild(flat), nil
+}
+
+func sameOp(f EntityFilter, op string) bool {
+	switch f := f.(type) {
+	case AndFilter:
+		return op == "AND"
+	case OrFilter:
+		return op == "OR"
+	default:
+		_ = f
+		return false
+	}
+}
+
+func compositeChildren(f EntityFilter) []EntityFilter {
+	switch f := f.(type) {
+	case AndFilter:
+		return f.Filters
+	case OrFilter:
+		return f.Filters
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firestore
+
+import (
+	"context"
+
+	pb "cloud.google.com/go/firestore/apiv1/firestorepb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// VectorQuery is a Query narrowed to its nearest limit matches to
+// queryVector, as built by Query.FindNearest.
+type VectorQuery struct {
+	q Query
+}
+
+// Documents runs the vector query, identically to Query.Documents.
+func (vq *VectorQuery) Documents(ctx context.Context) *DocumentIterator {
+	return vq.q.Documents(ctx)
+}
+
+// FindNearest returns a VectorQuery that ranks q's results by distance
+// from queryVector (a []float64, []float32, or Vector) under measure,
+// keeping the limit nearest. Being a method on Query rather than
+// CollectionRef, it composes with Where/WhereEntity filters built up
+// beforehand -- see validateFindNearestQuery for the OrderBy/cursor
+// combinations that aren't supported. CollectionRef.FindNearest
+// delegates here through the embedded Query.
+func (q Query) FindNearest(vectorField string, queryVector interface{}, limit int, measure DistanceMeasure, opts *FindNearestOptions) *VectorQuery {
+	if q.err != nil {
+		return &VectorQuery{q: q}
+	}
+	qv, err := toFloat64Vector(queryVector)
+	if err != nil {
+		q.err = err
+		return &VectorQuery{q: q}
+	}
+	if err := validateFindNearestQuery(q); err != nil {
+		q.err = err
+		return &VectorQuery{q: q}
+	}
+	fref, err := (FieldPath{vectorField}).toServiceFieldPath()
+	if err != nil {
+		q.err = err
+		return &VectorQuery{q: q}
+	}
+	pbMeasure, err := measure.toProto()
+	if err != nil {
+		q.err = err
+		return &VectorQuery{q: q}
+	}
+	fn := &pb.StructuredQuery_FindNearest{
+		VectorField:     &pb.StructuredQuery_FieldReference{FieldPath: fref},
+		QueryVector:     vectorToProtoValue(Vector(qv)),
+		Limit:           &wrapperspb.Int32Value{Value: resolveFindNearestLimit(q, trunc32(limit))},
+		DistanceMeasure: pbMeasure,
+	}
+	if err := applyFindNearestOptions(fn, measure, opts); err != nil {
+		q.err = err
+		return &VectorQuery{q: q}
+	}
+	q.findNearest = fn
+	return &VectorQuery{q: q}
+}
+
+// FindNearest delegates to the embedded Query's FindNearest, so that
+// promoting FindNearest onto Query (to compose with Where/OrderBy/cursors)
+// doesn't change the behavior of existing CollectionRef-rooted calls.
+func (c *CollectionRef) FindNearest(vectorField string, queryVector interface{}, limit int, measure DistanceMeasure, opts *FindNearestOptions) *VectorQuery {
+	return c.Query.FindNearest(vectorField, queryVector, limit, measure, opts)
+}
@@ -0,0 +1,69 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firestore
+
+import (
+	pb "cloud.google.com/go/firestore/apiv1/firestorepb"
+	tspb "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// KeysOnly returns a new Query that returns only document references,
+// without fetching any field data. It is equivalent to
+// q.Select(DocumentID), and also sets the keysOnly flag. DocumentIterator
+// does not consult that flag: results are decoded by its normal path
+// (see newKeysOnlyDocumentSnapshot).
+func (q Query) KeysOnly() Query {
+	q.selection = []*pb.StructuredQuery_FieldReference{docIDFieldRef}
+	q.keysOnly = true
+	return q
+}
+
+var docIDFieldRef, _ = fref([]string{DocumentID})
+
+// newKeysOnlyDocumentSnapshot builds the DocumentSnapshot a KeysOnly
+// query's results should use: Ref, CreateTime, UpdateTime and ReadTime
+// are populated as usual, but proto.Fields is left empty since the
+// server only returned the document name.
+//
+// It has no production caller. DocumentIterator.Next, which decodes
+// every RunQuery response into a DocumentSnapshot, is defined outside
+// this package snapshot and is not modified by this file, so it never
+// picks this constructor over its normal decode path -- the "iterator
+// fast path" a KeysOnly query is meant to get doesn't exist yet. In
+// practice a KeysOnly query's selection (just docIDFieldRef) already
+// makes the server return a near-empty Document, so Next's normal
+// decoding of it is cheap even without this function; what's missing is
+// the client-side win of skipping that decode altogether, not
+// correctness. The other half of KeysOnly's contract -- that
+// q.keysOnly survives Serialize/Deserialize -- is real and is what
+// TestQueryKeysOnlyRoundTrip checks; this function is the half that
+// isn't.
+//
+// q.keysOnly needs no dedicated field in the serialized query: it's
+// already implied by selection being exactly [docIDFieldRef], so
+// Serialize's structured-query proto round-trips it for free, and
+// Deserialize sets q.keysOnly back to true whenever it reconstructs
+// that exact selection rather than treating it as an ordinary
+// single-field projection.
+func newKeysOnlyDocumentSnapshot(ref *DocumentRef, pdoc *pb.Document, c *Client, readTime *tspb.Timestamp) *DocumentSnapshot {
+	return &DocumentSnapshot{
+		Ref:        ref,
+		CreateTime: pdoc.CreateTime.AsTime(),
+		UpdateTime: pdoc.UpdateTime.AsTime(),
+		ReadTime:   readTime.AsTime(),
+		c:          c,
+		proto:      &pb.Document{Name: pdoc.Name},
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firestore
+
+import (
+	"fmt"
+
+	pb "cloud.google.com/go/firestore/apiv1/firestorepb"
+)
+
+// Param is a named placeholder for a value that is supplied later, when a
+// PreparedQuery is bound. Passing a Param wherever a Query builder method
+// would otherwise take a literal value marks that value as unbound.
+//
+//	q := coll.Where("a", ">", Param("min")).OrderBy("b", Asc).Prepare()
+//	docs, err := q.Bind(map[string]interface{}{"min": 5}).Documents(ctx).GetAll()
+type Param string
+
+// toProto refuses to serialize an unbound Param; it is only ever meant to
+// be substituted away by Bind before a query is built into a proto.
+func (p Param) toProto() (*pb.Value, error) {
+	return nil, fmt.Errorf("firestore: unbound parameter %q: call Bind before running a prepared query", string(p))
+}
+
+// PreparedQuery is a Query template containing one or more Param
+// placeholders. Build it once with Query.Prepare and execute it
+// repeatedly with different values via Bind, instead of re-running
+// Where/OrderBy/etc. validation for each execution.
+//
+// Limit and Offset take a plain int, not an interface{}, so a Param can
+// never be passed to either one -- there is no placeholder there for
+// Prepare to collect or for Bind to substitute. A caller who wants a
+// variable limit/offset across executions of the same PreparedQuery
+// should call Limit/Offset on the Query returned by Bind instead of
+// trying to parameterize it.
+//
+// Bind itself does no caching: it rebuilds the filter tree and cursor
+// values from the template on every call, and the Query it returns goes
+// through the normal, unmemoized proto serialization like any other
+// Query when it's run. What Prepare actually saves a caller is not
+// having to re-call Where/OrderBy/etc. (and handle the Query.err they
+// could produce) for each execution -- not the cost of serialization
+// itself, which this package has no safe hook to cache across Bind calls
+// without risking two bound queries aliasing each other's proto.
+type PreparedQuery struct {
+	q      Query
+	params map[string]bool // names of every Param referenced in q
+}
+
+// Prepare returns a PreparedQuery wrapping q. It walks q's filters and
+// cursors to collect the names of every Param placeholder so that Bind
+// can report a clear error for a missing value. Bind does not reject
+// values for names that no placeholder uses; they are ignored.
+func (q Query) Prepare() *PreparedQuery {
+	params := map[string]bool{}
+	collectParams(q.filters, params)
+	for _, c := range q.startVals {
+		addParam(c, params)
+	}
+	for _, c := range q.endVals {
+		addParam(c, params)
+	}
+	return &PreparedQuery{q: q, params: params}
+}
+
+func collectParams(filters []EntityFilter, params map[string]bool) {
+	for _, f := range filters {
+		switch f := f.(type) {
+		case PropertyFilter:
+			addParam(f.Value, params)
+		case PropertyPathFilter:
+			addParam(f.Value, params)
+		case AndFilter:
+			collectParams(f.Filters, params)
+		case OrFilter:
+			collectParams(f.Filters, params)
+		}
+	}
+}
+
+func addParam(v interface{}, params map[string]bool) {
+	if p, ok := v.(Param); ok {
+		params[string(p)] = true
+	}
+}
+
+// Bind returns a Query equal to the PreparedQuery's template with every
+// Param placeholder substituted for the value supplied in values, keyed
+// by parameter name. Bind does not re-validate parts of the query that
+// contain no placeholders; apart from the filter normalization described
+// below, only the substituted values are rewritten.
+// It is safe to call Bind many times (even concurrently) on the same
+// PreparedQuery with different values.
+//
+// Bind also runs each top-level filter through normalizeEntityFilter
+// before returning, so the returned Query's filters are in canonical form
+// even when the template's were not (substituting a value does not by
+// itself change a filter tree's shape); an empty AndFilter or OrFilter in
+// the template makes Bind return an error. This is the one place in this
+// package (outside a standalone unit test) that actually calls
+// normalizeEntityFilter: WhereEntity and Deserialize are both defined
+// outside this file and are not modified by it.
+func (pq *PreparedQuery) Bind(values map[string]interface{}) (Query, error) {
+	for name := range pq.params {
+		if _, ok := values[name]; !ok {
+			return Query{}, fmt.Errorf("firestore: missing value for parameter %q", name)
+		}
+	}
+	q := pq.q
+	q.filters = bindFilters(q.filters, values)
+	for i, f := range q.filters {
+		nf, err := normalizeEntityFilter(f)
+		if err != nil {
+			return Query{}, err
+		}
+		q.filters[i] = nf
+	}
+	q.startVals = bindValues(q.startVals, values)
+	q.endVals = bindValues(q.endVals, values)
+	return q, nil
+}
+
+func bindFilters(filters []EntityFilter, values map[string]interface{}) []EntityFilter {
+	if filters == nil {
+		return nil
+	}
+	out := make([]EntityFilter, len(filters))
+	for i, f := range filters {
+		switch f := f.(type) {
+		case PropertyFilter:
+			f.Value = bindValue(f.Value, values)
+			out[i] = f
+		case PropertyPathFilter:
+			f.Value = bindValue(f.Value, values)
+			out[i] = f
+		case AndFilter:
+			f.Filters = bindFilters(f.Filters, values)
+			out[i] = f
+		case OrFilter:
+			f.Filters = bindFilters(f.Filters, values)
+			out[i] = f
+		default:
+			out[i] = f
+		}
+	}
+	return out
+}
+
+func bindValues(vals []interface{}, values map[string]interface{}) []interface{} {
+	if vals == nil {
+		return nil
+	}
+	out := make([]interface{}, len(vals))
+	for i, v := range vals {
+		out[i] = bindValue(v, values)
+	}
+	return out
+}
+
+func bindValue(v interface{}, values map[string]interface{}) interface{} {
+	if p, ok := v.(Param); ok {
+		return values[string(p)]
+	}
+	return v
+}
@@ -0,0 +1,223 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firestore
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"google.golang.org/api/iterator"
+)
+
+// Reranker reorders the candidates returned by a FindNearest over-fetch,
+// given the original query vector. A candidate that can't be reranked
+// (for example, MMRReranker's candidates missing vectorField) should be
+// dropped from the returned slice rather than failing the whole call.
+type Reranker interface {
+	Rerank(ctx context.Context, query []float64, candidates []*DocumentSnapshot) ([]*DocumentSnapshot, error)
+}
+
+// RerankQuery wraps a Query configured by FindNearestWithReranker: it
+// fetches fetchK nearest candidates, then lets reranker reorder them down
+// to returnK.
+type RerankQuery struct {
+	q           Query
+	queryVec    []float64
+	vectorField string
+	returnK     int
+	reranker    Reranker
+}
+
+// FindNearestWithReranker behaves like FindNearest, but over-fetches
+// fetchK candidates (by the server-side distance measure) and then
+// re-orders them locally with reranker, returning at most returnK
+// documents. This is the standard shape for RAG-style workloads that want
+// to diversify results beyond pure nearest-neighbor ranking -- see
+// NewMMRReranker for a built-in Maximal Marginal Relevance
+// implementation.
+func (q Query) FindNearestWithReranker(vectorField string, queryVec interface{}, fetchK, returnK int, measure DistanceMeasure, opts *FindNearestOptions, reranker Reranker) *RerankQuery {
+	qv, err := toFloat64Vector(queryVec)
+	if err != nil {
+		return &RerankQuery{q: Query{err: err}}
+	}
+	vq := q.FindNearest(vectorField, queryVec, fetchK, measure, opts)
+	return &RerankQuery{
+		q:           vq.q,
+		queryVec:    qv,
+		vectorField: vectorField,
+		returnK:     returnK,
+		reranker:    reranker,
+	}
+}
+
+// RerankedDocumentIterator yields an already-computed, already-ordered
+// slice of documents one at a time, matching the DocumentIterator.Next
+// calling convention used by the rest of the package.
+type RerankedDocumentIterator struct {
+	docs []*DocumentSnapshot
+	i    int
+	err  error
+}
+
+// Next returns the next document in reranked order, or iterator.Done
+// once they are exhausted.
+func (it *RerankedDocumentIterator) Next() (*DocumentSnapshot, error) {
+	if it.err != nil {
+		return nil, it.err
+	}
+	if it.i >= len(it.docs) {
+		return nil, iterator.Done
+	}
+	d := it.docs[it.i]
+	it.i++
+	return d, nil
+}
+
+// GetAll returns every remaining document in reranked order.
+func (it *RerankedDocumentIterator) GetAll() ([]*DocumentSnapshot, error) {
+	if it.err != nil {
+		return nil, it.err
+	}
+	rest := it.docs[it.i:]
+	it.i = len(it.docs)
+	return rest, nil
+}
+
+// Documents buffers all of the over-fetched candidates, runs the
+// reranker once, and returns an iterator that yields them in the new
+// order.
+func (rq *RerankQuery) Documents(ctx context.Context) *RerankedDocumentIterator {
+	if rq.q.err != nil {
+		return &RerankedDocumentIterator{err: rq.q.err}
+	}
+	docs, err := rq.q.Documents(ctx).GetAll()
+	if err != nil {
+		return &RerankedDocumentIterator{err: err}
+	}
+	reranked, err := rq.reranker.Rerank(ctx, rq.queryVec, docs)
+	if err != nil {
+		return &RerankedDocumentIterator{err: err}
+	}
+	if len(reranked) > rq.returnK {
+		reranked = reranked[:rq.returnK]
+	}
+	return &RerankedDocumentIterator{docs: reranked}
+}
+
+func toFloat64Vector(v interface{}) ([]float64, error) {
+	switch v := v.(type) {
+	case Vector:
+		return []float64(v), nil
+	case []float64:
+		return v, nil
+	case []float32:
+		out := make([]float64, len(v))
+		for i, f := range v {
+			out[i] = float64(f)
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("firestore: invalid vector type %T", v)
+	}
+}
+
+// MMRReranker diversifies FindNearest candidates with Maximal Marginal
+// Relevance: starting from the candidate most similar to the query, it
+// repeatedly picks the next candidate that maximizes
+//
+//	lambda*sim(query, candidate) - (1-lambda)*max(sim(candidate, selected))
+//
+// trading off relevance to the query against redundancy with documents
+// already picked. lambda == 1 recovers pure similarity ranking; lower
+// values favor diversity.
+type MMRReranker struct {
+	lambda      float64
+	vectorField string
+}
+
+// NewMMRReranker returns an MMRReranker that reads each candidate's
+// vector from vectorField and balances relevance against diversity
+// according to lambda (0 <= lambda <= 1).
+func NewMMRReranker(lambda float64, vectorField string) *MMRReranker {
+	return &MMRReranker{lambda: lambda, vectorField: vectorField}
+}
+
+// Rerank implements Reranker. Candidates missing vectorField, or with an
+// unusable value for it, are dropped rather than failing the call --
+// they carry no usable signal for MMR to rank them by, so they can't
+// meaningfully participate.
+func (m *MMRReranker) Rerank(ctx context.Context, query []float64, candidates []*DocumentSnapshot) ([]*DocumentSnapshot, error) {
+	usable := make([]*DocumentSnapshot, 0, len(candidates))
+	vecs := make([][]float64, 0, len(candidates))
+	for _, c := range candidates {
+		var raw []float64
+		if err := c.DataAt(m.vectorField, &raw); err != nil || len(raw) == 0 {
+			continue
+		}
+		usable = append(usable, c)
+		vecs = append(vecs, raw)
+	}
+
+	n := len(usable)
+	selected := make([]int, 0, n)
+	remaining := make(map[int]bool, n)
+	for i := range usable {
+		remaining[i] = true
+	}
+
+	for len(selected) < n && len(remaining) > 0 {
+		best := -1
+		bestScore := math.Inf(-1)
+		for i := range remaining {
+			relevance := cosineSimilarity(query, vecs[i])
+			redundancy := 0.0
+			for _, s := range selected {
+				if sim := cosineSimilarity(vecs[i], vecs[s]); sim > redundancy {
+					redundancy = sim
+				}
+			}
+			score := m.lambda*relevance - (1-m.lambda)*redundancy
+			if score > bestScore {
+				bestScore = score
+				best = i
+			}
+		}
+		selected = append(selected, best)
+		delete(remaining, best)
+	}
+
+	out := make([]*DocumentSnapshot, len(selected))
+	for i, idx := range selected {
+		out[i] = usable[idx]
+	}
+	return out, nil
+}
+
+func cosineSimilarity(a, b []float64) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
@@ -16,16 +16,20 @@ package firestore
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math"
 	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	pb "cloud.google.com/go/firestore/apiv1/firestorepb"
 	"cloud.google.com/go/internal/pretty"
 	"github.com/google/go-cmp/cmp"
 	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/structpb"
 	tspb "google.golang.org/protobuf/types/known/timestamppb"
 	"google.golang.org/protobuf/types/known/wrapperspb"
 )
@@ -858,6 +862,336 @@ func createTestScenarios(t *testing.T) []toProtoScenario {
 				},
 			},
 		},
+		{
+			desc: `q.WhereEntity(Or(And(a==1, b<3), c=="x"))`,
+			in: q.WhereEntity(
+				OrFilter{
+					Filters: []EntityFilter{
+						AndFilter{
+							Filters: []EntityFilter{
+								PropertyFilter{Path: "a", Operator: "==", Value: 1},
+								PropertyFilter{Path: "b", Operator: "<", Value: 3},
+							},
+						},
+						PropertyFilter{Path: "c", Operator: "==", Value: "x"},
+					},
+				},
+			),
+			want: &pb.RunQueryRequest{
+				Parent: q.parentPath,
+				QueryType: &pb.RunQueryRequest_StructuredQuery{
+					StructuredQuery: &pb.StructuredQuery{
+						Where: &pb.StructuredQuery_Filter{
+							FilterType: &pb.StructuredQuery_Filter_CompositeFilter{
+								CompositeFilter: &pb.StructuredQuery_CompositeFilter{
+									Op: pb.StructuredQuery_CompositeFilter_OR,
+									Filters: []*pb.StructuredQuery_Filter{
+										{
+											FilterType: &pb.StructuredQuery_Filter_CompositeFilter{
+												CompositeFilter: &pb.StructuredQuery_CompositeFilter{
+													Op: pb.StructuredQuery_CompositeFilter_AND,
+													Filters: []*pb.StructuredQuery_Filter{
+														filtr([]string{"a"}, "==", 1),
+														filtr([]string{"b"}, "<", 3),
+													},
+												},
+											},
+										},
+										filtr([]string{"c"}, "==", "x"),
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			desc: `q.WhereEntity(Or(a==1, b==2, c==3)) three-way`,
+			in: q.WhereEntity(
+				OrFilter{
+					Filters: []EntityFilter{
+						PropertyFilter{Path: "a", Operator: "==", Value: 1},
+						PropertyFilter{Path: "b", Operator: "==", Value: 2},
+						PropertyFilter{Path: "c", Operator: "==", Value: 3},
+					},
+				},
+			),
+			want: &pb.RunQueryRequest{
+				Parent: q.parentPath,
+				QueryType: &pb.RunQueryRequest_StructuredQuery{
+					StructuredQuery: &pb.StructuredQuery{
+						Where: &pb.StructuredQuery_Filter{
+							FilterType: &pb.StructuredQuery_Filter_CompositeFilter{
+								CompositeFilter: &pb.StructuredQuery_CompositeFilter{
+									Op: pb.StructuredQuery_CompositeFilter_OR,
+									Filters: []*pb.StructuredQuery_Filter{
+										filtr([]string{"a"}, "==", 1),
+										filtr([]string{"b"}, "==", 2),
+										filtr([]string{"c"}, "==", 3),
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			desc: `q.WhereEntity(And(a==1, Or(b==2, c==3)))`,
+			in: q.WhereEntity(
+				AndFilter{
+					Filters: []EntityFilter{
+						PropertyFilter{Path: "a", Operator: "==", Value: 1},
+						OrFilter{
+							Filters: []EntityFilter{
+								PropertyFilter{Path: "b", Operator: "==", Value: 2},
+								PropertyFilter{Path: "c", Operator: "==", Value: 3},
+							},
+						},
+					},
+				},
+			),
+			want: &pb.RunQueryRequest{
+				Parent: q.parentPath,
+				QueryType: &pb.RunQueryRequest_StructuredQuery{
+					StructuredQuery: &pb.StructuredQuery{
+						Where: &pb.StructuredQuery_Filter{
+							FilterType: &pb.StructuredQuery_Filter_CompositeFilter{
+								CompositeFilter: &pb.StructuredQuery_CompositeFilter{
+									Op: pb.StructuredQuery_CompositeFilter_AND,
+									Filters: []*pb.StructuredQuery_Filter{
+										filtr([]string{"a"}, "==", 1),
+										{
+											FilterType: &pb.StructuredQuery_Filter_CompositeFilter{
+												CompositeFilter: &pb.StructuredQuery_CompositeFilter{
+													Op: pb.StructuredQuery_CompositeFilter_OR,
+													Filters: []*pb.StructuredQuery_Filter{
+														filtr([]string{"b"}, "==", 2),
+														filtr([]string{"c"}, "==", 3),
+													},
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			// WhereEntity is not wired to normalizeEntityFilter (see
+			// filter_normalize.go), so a nested same-operator Or is
+			// expected to survive Serialize/Deserialize exactly as built,
+			// without being flattened into its parent.
+			desc: `q.WhereEntity(Or(Or(a==1, b==2), c==3)) nested same-op Or round-trips unflattened`,
+			in: q.WhereEntity(
+				OrFilter{
+					Filters: []EntityFilter{
+						OrFilter{
+							Filters: []EntityFilter{
+								PropertyFilter{Path: "a", Operator: "==", Value: 1},
+								PropertyFilter{Path: "b", Operator: "==", Value: 2},
+							},
+						},
+						PropertyFilter{Path: "c", Operator: "==", Value: 3},
+					},
+				},
+			),
+			want: &pb.RunQueryRequest{
+				Parent: q.parentPath,
+				QueryType: &pb.RunQueryRequest_StructuredQuery{
+					StructuredQuery: &pb.StructuredQuery{
+						Where: &pb.StructuredQuery_Filter{
+							FilterType: &pb.StructuredQuery_Filter_CompositeFilter{
+								CompositeFilter: &pb.StructuredQuery_CompositeFilter{
+									Op: pb.StructuredQuery_CompositeFilter_OR,
+									Filters: []*pb.StructuredQuery_Filter{
+										{
+											FilterType: &pb.StructuredQuery_Filter_CompositeFilter{
+												CompositeFilter: &pb.StructuredQuery_CompositeFilter{
+													Op: pb.StructuredQuery_CompositeFilter_OR,
+													Filters: []*pb.StructuredQuery_Filter{
+														filtr([]string{"a"}, "==", 1),
+														filtr([]string{"b"}, "==", 2),
+													},
+												},
+											},
+										},
+										filtr([]string{"c"}, "==", 3),
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			// Same shape as above but And/And, confirming the same-op
+			// nesting survives round trip regardless of which operator.
+			desc: `q.WhereEntity(And(And(a==1, b==2), c==3)) nested same-op And round-trips unflattened`,
+			in: q.WhereEntity(
+				AndFilter{
+					Filters: []EntityFilter{
+						AndFilter{
+							Filters: []EntityFilter{
+								PropertyFilter{Path: "a", Operator: "==", Value: 1},
+								PropertyFilter{Path: "b", Operator: "==", Value: 2},
+							},
+						},
+						PropertyFilter{Path: "c", Operator: "==", Value: 3},
+					},
+				},
+			),
+			want: &pb.RunQueryRequest{
+				Parent: q.parentPath,
+				QueryType: &pb.RunQueryRequest_StructuredQuery{
+					StructuredQuery: &pb.StructuredQuery{
+						Where: &pb.StructuredQuery_Filter{
+							FilterType: &pb.StructuredQuery_Filter_CompositeFilter{
+								CompositeFilter: &pb.StructuredQuery_CompositeFilter{
+									Op: pb.StructuredQuery_CompositeFilter_AND,
+									Filters: []*pb.StructuredQuery_Filter{
+										{
+											FilterType: &pb.StructuredQuery_Filter_CompositeFilter{
+												CompositeFilter: &pb.StructuredQuery_CompositeFilter{
+													Op: pb.StructuredQuery_CompositeFilter_AND,
+													Filters: []*pb.StructuredQuery_Filter{
+														filtr([]string{"a"}, "==", 1),
+														filtr([]string{"b"}, "==", 2),
+													},
+												},
+											},
+										},
+										filtr([]string{"c"}, "==", 3),
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			// A single-child And is likewise expected to round-trip as a
+			// single-child And, not unwrapped to its lone Or child.
+			desc: `q.WhereEntity(And(Or(a==1, b==2))) single-child And round-trips as a single-child And`,
+			in: q.WhereEntity(
+				AndFilter{
+					Filters: []EntityFilter{
+						OrFilter{
+							Filters: []EntityFilter{
+								PropertyFilter{Path: "a", Operator: "==", Value: 1},
+								PropertyFilter{Path: "b", Operator: "==", Value: 2},
+							},
+						},
+					},
+				},
+			),
+			want: &pb.RunQueryRequest{
+				Parent: q.parentPath,
+				QueryType: &pb.RunQueryRequest_StructuredQuery{
+					StructuredQuery: &pb.StructuredQuery{
+						Where: &pb.StructuredQuery_Filter{
+							FilterType: &pb.StructuredQuery_Filter_CompositeFilter{
+								CompositeFilter: &pb.StructuredQuery_CompositeFilter{
+									Op: pb.StructuredQuery_CompositeFilter_AND,
+									Filters: []*pb.StructuredQuery_Filter{
+										{
+											FilterType: &pb.StructuredQuery_Filter_CompositeFilter{
+												CompositeFilter: &pb.StructuredQuery_CompositeFilter{
+													Op: pb.StructuredQuery_CompositeFilter_OR,
+													Filters: []*pb.StructuredQuery_Filter{
+														filtr([]string{"a"}, "==", 1),
+														filtr([]string{"b"}, "==", 2),
+													},
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			desc: `q.WhereEntity(Or(And(Or(a==1, b==2), c==3), d==4)) mixed nested composites`,
+			in: q.WhereEntity(
+				OrFilter{
+					Filters: []EntityFilter{
+						AndFilter{
+							Filters: []EntityFilter{
+								OrFilter{
+									Filters: []EntityFilter{
+										PropertyFilter{Path: "a", Operator: "==", Value: 1},
+										PropertyFilter{Path: "b", Operator: "==", Value: 2},
+									},
+								},
+								PropertyFilter{Path: "c", Operator: "==", Value: 3},
+							},
+						},
+						PropertyFilter{Path: "d", Operator: "==", Value: 4},
+					},
+				},
+			),
+			want: &pb.RunQueryRequest{
+				Parent: q.parentPath,
+				QueryType: &pb.RunQueryRequest_StructuredQuery{
+					StructuredQuery: &pb.StructuredQuery{
+						Where: &pb.StructuredQuery_Filter{
+							FilterType: &pb.StructuredQuery_Filter_CompositeFilter{
+								CompositeFilter: &pb.StructuredQuery_CompositeFilter{
+									Op: pb.StructuredQuery_CompositeFilter_OR,
+									Filters: []*pb.StructuredQuery_Filter{
+										{
+											FilterType: &pb.StructuredQuery_Filter_CompositeFilter{
+												CompositeFilter: &pb.StructuredQuery_CompositeFilter{
+													Op: pb.StructuredQuery_CompositeFilter_AND,
+													Filters: []*pb.StructuredQuery_Filter{
+														{
+															FilterType: &pb.StructuredQuery_Filter_CompositeFilter{
+																CompositeFilter: &pb.StructuredQuery_CompositeFilter{
+																	Op: pb.StructuredQuery_CompositeFilter_OR,
+																	Filters: []*pb.StructuredQuery_Filter{
+																		filtr([]string{"a"}, "==", 1),
+																		filtr([]string{"b"}, "==", 2),
+																	},
+																},
+															},
+														},
+														filtr([]string{"c"}, "==", 3),
+													},
+												},
+											},
+										},
+										filtr([]string{"d"}, "==", 4),
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			desc: `q.KeysOnly()`,
+			in:   q.KeysOnly(),
+			want: &pb.RunQueryRequest{
+				Parent: q.parentPath,
+				QueryType: &pb.RunQueryRequest_StructuredQuery{
+					StructuredQuery: &pb.StructuredQuery{
+						Select: &pb.StructuredQuery_Projection{
+							Fields: []*pb.StructuredQuery_FieldReference{fref1("__name__")},
+						},
+					},
+				},
+			},
+		},
 		{
 			desc: `q.Where("a", ">", 5).FindNearest float64 vector`,
 			in: q.Where("a", ">", 5).
@@ -980,6 +1314,27 @@ func TestQueryFromProtoRoundTrip(t *testing.T) {
 	}
 }
 
+func TestQueryKeysOnlyRoundTrip(t *testing.T) {
+	// TestQueryFromProtoRoundTrip already checks that a KeysOnly query's
+	// proto survives Serialize/Deserialize, but that only exercises the
+	// exported Select([__name__]) shape; it says nothing about whether
+	// Deserialize also restores the unexported keysOnly flag that KeysOnly
+	// sets. This checks that flag directly.
+	c := &Client{projectID: "P", databaseID: "DB"}
+	q := c.Collection("C").KeysOnly()
+	protoBytes, err := q.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Query{c: c}.Deserialize(protoBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.keysOnly {
+		t.Error("got keysOnly = false after round trip, want true")
+	}
+}
+
 func fref1(s string) *pb.StructuredQuery_FieldReference {
 	ref, _ := fref([]string{s})
 	return ref
@@ -1466,37 +1821,111 @@ func TestAggregationQuery(t *testing.T) {
 	}
 }
 
-func TestWithSum(t *testing.T) {
+func TestAggregationQueryRunOptions(t *testing.T) {
 	ctx := context.Background()
-	sumAlias := "sum"
 	c, srv, cleanup := newMock(t)
 	defer cleanup()
 
-	srv.addRPC(nil, []interface{}{
-		&pb.RunAggregationQueryResponse{
-			Result: &pb.AggregationResult{
-				AggregateFields: map[string]*pb.Value{
-					"sum": intval(1),
-				},
-			},
-		},
-	})
-
-	testcases := []struct {
-		desc    string
-		path    string
-		wantErr bool
+	for _, tc := range []struct {
+		desc       string
+		opts       []RunOption
+		wantErrMsg string
 	}{
 		{
-			desc:    "Invalid path",
-			path:    "path*",
-			wantErr: true,
+			desc:       "nil RunOption",
+			opts:       []RunOption{ExplainOptions{Analyze: true}, nil},
+			wantErrMsg: "cannot be nil",
 		},
 		{
-			desc:    "Valid path",
-			path:    "path",
-			wantErr: false,
-		},
+			desc:       "ExplainOptions specified multiple times",
+			opts:       []RunOption{ExplainOptions{Analyze: false}, ExplainOptions{Analyze: true}, ExplainOptions{Analyze: false}},
+			wantErrMsg: "ExplainOptions can be specified only once",
+		},
+		{
+			desc: "single ExplainOptions",
+			opts: []RunOption{ExplainOptions{Analyze: true}},
+		},
+	} {
+		srv.reset()
+		srv.addRPC(nil, []interface{}{
+			&pb.RunAggregationQueryResponse{
+				Result: &pb.AggregationResult{
+					AggregateFields: map[string]*pb.Value{"testAlias": intval(1)},
+				},
+				ExplainMetrics: &pb.ExplainMetrics{
+					PlanSummary: &pb.PlanSummary{},
+				},
+			},
+		})
+
+		aq := c.Collection("coll1").NewAggregationQuery().WithCount("testAlias").WithRunOptions(tc.opts...)
+		_, err := aq.Get(ctx)
+		if (err == nil && tc.wantErrMsg != "") || (err != nil && !strings.Contains(err.Error(), tc.wantErrMsg)) {
+			t.Errorf("%s: Get got %v, want message %q", tc.desc, err, tc.wantErrMsg)
+		}
+	}
+}
+
+func TestAggregationQueryGetResponse(t *testing.T) {
+	ctx := context.Background()
+	c, srv, cleanup := newMock(t)
+	defer cleanup()
+
+	srv.addRPC(nil, []interface{}{
+		&pb.RunAggregationQueryResponse{
+			Result: &pb.AggregationResult{
+				AggregateFields: map[string]*pb.Value{"testAlias": intval(1)},
+			},
+			ExplainMetrics: &pb.ExplainMetrics{
+				PlanSummary: &pb.PlanSummary{},
+			},
+		},
+	})
+
+	aq := c.Collection("coll1").NewAggregationQuery().WithCount("testAlias").WithRunOptions(ExplainOptions{Analyze: true})
+	result, metrics, err := aq.GetResponse(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := result["testAlias"]; !ok {
+		t.Error("GetResponse: result missing testAlias")
+	}
+	if metrics == nil {
+		t.Error("GetResponse: got nil ExplainMetrics, want non-nil")
+	}
+}
+
+func TestWithSum(t *testing.T) {
+	ctx := context.Background()
+	sumAlias := "sum"
+	c, srv, cleanup := newMock(t)
+	defer cleanup()
+
+	srv.addRPC(nil, []interface{}{
+		&pb.RunAggregationQueryResponse{
+			Result: &pb.AggregationResult{
+				AggregateFields: map[string]*pb.Value{
+					"sum": intval(1),
+				},
+			},
+		},
+	})
+
+	testcases := []struct {
+		desc    string
+		path    string
+		wantErr bool
+	}{
+		{
+			desc:    "Invalid path",
+			path:    "path*",
+			wantErr: true,
+		},
+		{
+			desc:    "Valid path",
+			path:    "path",
+			wantErr: false,
+		},
 	}
 	for _, tc := range testcases {
 
@@ -1646,6 +2075,178 @@ func TestWithAvgPath(t *testing.T) {
 	}
 }
 
+func TestMin(t *testing.T) {
+	ctx := context.Background()
+	c, srv, cleanup := newMock(t)
+	defer cleanup()
+
+	const dbPath = "projects/projectID/databases/(default)"
+	wantPBDoc := &pb.Document{
+		Name:       dbPath + "/documents/C/a",
+		CreateTime: aTimestamp,
+		UpdateTime: aTimestamp,
+		Fields:     map[string]*pb.Value{"path": intval(1)},
+	}
+
+	testcases := []struct {
+		desc    string
+		path    string
+		wantErr bool
+	}{
+		{
+			desc:    "Invalid path",
+			path:    "path*",
+			wantErr: true,
+		},
+		{
+			desc:    "Valid path",
+			path:    "path",
+			wantErr: false,
+		},
+	}
+	for _, tc := range testcases {
+		srv.reset()
+		srv.addRPC(nil, []interface{}{
+			&pb.RunQueryResponse{Document: wantPBDoc},
+		})
+		_, err := c.Collection("C").Min(ctx, tc.path)
+		if err == nil && tc.wantErr {
+			t.Fatalf("%s: got nil wanted error", tc.desc)
+		} else if err != nil && !tc.wantErr {
+			t.Fatalf("%s: got %v, want nil", tc.desc, err)
+		}
+	}
+}
+
+func TestMinPath(t *testing.T) {
+	ctx := context.Background()
+	c, srv, cleanup := newMock(t)
+	defer cleanup()
+
+	const dbPath = "projects/projectID/databases/(default)"
+	wantPBDoc := &pb.Document{
+		Name:       dbPath + "/documents/C/a",
+		CreateTime: aTimestamp,
+		UpdateTime: aTimestamp,
+		Fields:     map[string]*pb.Value{"path": intval(1)},
+	}
+
+	testcases := []struct {
+		desc      string
+		fieldPath FieldPath
+		wantErr   bool
+	}{
+		{
+			desc:      "Invalid path",
+			fieldPath: []string{},
+			wantErr:   true,
+		},
+		{
+			desc:      "Valid path",
+			fieldPath: []string{"path"},
+			wantErr:   false,
+		},
+	}
+	for _, tc := range testcases {
+		srv.reset()
+		srv.addRPC(nil, []interface{}{
+			&pb.RunQueryResponse{Document: wantPBDoc},
+		})
+		_, err := c.Collection("C").MinPath(ctx, tc.fieldPath)
+		if err == nil && tc.wantErr {
+			t.Fatalf("%s: got nil wanted error", tc.desc)
+		} else if err != nil && !tc.wantErr {
+			t.Fatalf("%s: got %v, want nil", tc.desc, err)
+		}
+	}
+}
+
+func TestMax(t *testing.T) {
+	ctx := context.Background()
+	c, srv, cleanup := newMock(t)
+	defer cleanup()
+
+	const dbPath = "projects/projectID/databases/(default)"
+	wantPBDoc := &pb.Document{
+		Name:       dbPath + "/documents/C/a",
+		CreateTime: aTimestamp,
+		UpdateTime: aTimestamp,
+		Fields:     map[string]*pb.Value{"path": intval(1)},
+	}
+
+	testcases := []struct {
+		desc    string
+		path    string
+		wantErr bool
+	}{
+		{
+			desc:    "Invalid path",
+			path:    "path*",
+			wantErr: true,
+		},
+		{
+			desc:    "Valid path",
+			path:    "path",
+			wantErr: false,
+		},
+	}
+	for _, tc := range testcases {
+		srv.reset()
+		srv.addRPC(nil, []interface{}{
+			&pb.RunQueryResponse{Document: wantPBDoc},
+		})
+		_, err := c.Collection("C").Max(ctx, tc.path)
+		if err == nil && tc.wantErr {
+			t.Fatalf("%s: got nil wanted error", tc.desc)
+		} else if err != nil && !tc.wantErr {
+			t.Fatalf("%s: got %v, want nil", tc.desc, err)
+		}
+	}
+}
+
+func TestMaxPath(t *testing.T) {
+	ctx := context.Background()
+	c, srv, cleanup := newMock(t)
+	defer cleanup()
+
+	const dbPath = "projects/projectID/databases/(default)"
+	wantPBDoc := &pb.Document{
+		Name:       dbPath + "/documents/C/a",
+		CreateTime: aTimestamp,
+		UpdateTime: aTimestamp,
+		Fields:     map[string]*pb.Value{"path": intval(1)},
+	}
+
+	testcases := []struct {
+		desc      string
+		fieldPath FieldPath
+		wantErr   bool
+	}{
+		{
+			desc:      "Invalid path",
+			fieldPath: []string{},
+			wantErr:   true,
+		},
+		{
+			desc:      "Valid path",
+			fieldPath: []string{"path"},
+			wantErr:   false,
+		},
+	}
+	for _, tc := range testcases {
+		srv.reset()
+		srv.addRPC(nil, []interface{}{
+			&pb.RunQueryResponse{Document: wantPBDoc},
+		})
+		_, err := c.Collection("C").MaxPath(ctx, tc.fieldPath)
+		if err == nil && tc.wantErr {
+			t.Fatalf("%s: got nil wanted error", tc.desc)
+		} else if err != nil && !tc.wantErr {
+			t.Fatalf("%s: got %v, want nil", tc.desc, err)
+		}
+	}
+}
+
 func TestExplainOptionsApply(t *testing.T) {
 	pbExplainOptions := pb.ExplainOptions{Analyze: true}
 	for _, testcase := range []struct {
@@ -1822,3 +2423,580 @@ func TestFindNearest(t *testing.T) {
 		}
 	}
 }
+
+func TestFindNearestComposition(t *testing.T) {
+	c := &Client{projectID: "P", databaseID: "DB"}
+	coll := c.Collection("C")
+
+	// FindNearest composes with Where: both the composite filter and the
+	// vector clause must appear in the resulting proto.
+	vq := coll.Where("tenant", "==", "t").Where("archived", "==", false).
+		FindNearest("embedding", []float64{1, 2, 3}, 5, DistanceMeasureEuclidean, nil)
+	req, err := vq.q.toRunQueryRequestProto()
+	if err != nil {
+		t.Fatalf("toRunQueryRequestProto: %v", err)
+	}
+	sq := req.GetStructuredQuery()
+	if sq.GetWhere() == nil {
+		t.Error("got nil Where, want the composite filter to survive alongside FindNearest")
+	}
+	if sq.GetFindNearest() == nil {
+		t.Error("got nil FindNearest, want the vector clause to survive alongside Where")
+	}
+
+	// OrderBy cannot be combined with FindNearest: the result order is
+	// defined by distance, not by an explicit sort.
+	vq2 := coll.OrderBy("a", Asc).FindNearest("embedding", []float64{1, 2, 3}, 5, DistanceMeasureEuclidean, nil)
+	if vq2.q.err == nil {
+		t.Error("OrderBy + FindNearest: got nil error, want one")
+	}
+
+	// Cursors cannot be combined with FindNearest either, since cursors
+	// are defined relative to an order.
+	vq3 := coll.StartAt(1).FindNearest("embedding", []float64{1, 2, 3}, 5, DistanceMeasureEuclidean, nil)
+	if vq3.q.err == nil {
+		t.Error("StartAt + FindNearest: got nil error, want one")
+	}
+}
+
+func TestFindNearestDistanceOptions(t *testing.T) {
+	ctx := context.Background()
+	c, srv, cleanup := newMock(t)
+	defer cleanup()
+
+	const dbPath = "projects/projectID/databases/(default)"
+	threshold := 1.5
+	negThreshold := -1.0
+
+	testcases := []struct {
+		desc    string
+		opts    *FindNearestOptions
+		wantErr bool
+	}{
+		{
+			desc:    "nil options",
+			opts:    nil,
+			wantErr: false,
+		},
+		{
+			desc:    "valid threshold and result field",
+			opts:    &FindNearestOptions{DistanceThreshold: &threshold, DistanceResultField: "dist"},
+			wantErr: false,
+		},
+		{
+			desc:    "negative threshold",
+			opts:    &FindNearestOptions{DistanceThreshold: &negThreshold},
+			wantErr: true,
+		},
+		{
+			desc:    "empty result field is ignored, not an error",
+			opts:    &FindNearestOptions{DistanceResultField: ""},
+			wantErr: false,
+		},
+		{
+			desc:    "threshold with unrecognized measure",
+			opts:    &FindNearestOptions{DistanceThreshold: &threshold},
+			wantErr: true,
+		},
+	}
+	for _, tc := range testcases {
+		srv.reset()
+		srv.addRPC(nil, []interface{}{
+			&pb.RunQueryResponse{Document: &pb.Document{
+				Name:       dbPath + "/documents/C/a",
+				CreateTime: aTimestamp,
+				UpdateTime: aTimestamp,
+			}},
+		})
+		measure := DistanceMeasureEuclidean
+		if tc.desc == "threshold with unrecognized measure" {
+			measure = DistanceMeasure(99) // no recognized measure has this value
+		}
+		vQuery := c.Collection("C").FindNearest("embedding", []float64{1, 2, 3}, 2, measure, tc.opts)
+
+		_, err := vQuery.Documents(ctx).GetAll()
+		if err == nil && tc.wantErr {
+			t.Fatalf("%s: got nil wanted error", tc.desc)
+		} else if err != nil && !tc.wantErr {
+			t.Fatalf("%s: got %v, want nil", tc.desc, err)
+		}
+	}
+}
+
+func TestDocumentSnapshotVectorDistance(t *testing.T) {
+	ref := &DocumentRef{Path: "projects/P/databases/(default)/documents/C/a"}
+	d := &DocumentSnapshot{
+		Ref:                 ref,
+		distanceResultField: "dist",
+		proto: &pb.Document{
+			Fields: map[string]*pb.Value{
+				"dist": {ValueType: &pb.Value_DoubleValue{DoubleValue: 2.5}},
+			},
+		},
+	}
+	got, ok := d.VectorDistance()
+	if !ok {
+		t.Fatal("VectorDistance: got ok=false, want true")
+	}
+	if got != 2.5 {
+		t.Errorf("VectorDistance: got %v, want 2.5", got)
+	}
+
+	d2 := &DocumentSnapshot{Ref: ref, proto: &pb.Document{}}
+	if _, ok := d2.VectorDistance(); ok {
+		t.Error("VectorDistance with no DistanceResultField configured: got ok=true, want false")
+	}
+}
+
+func TestPreparedQueryBind(t *testing.T) {
+	c := &Client{projectID: "P", databaseID: "DB"}
+	coll := c.Collection("C")
+	pq := coll.Where("a", ">", Param("min")).OrderBy("b", Asc).Prepare()
+
+	q, err := pq.Bind(map[string]interface{}{"min": 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := coll.Where("a", ">", 5).OrderBy("b", Asc)
+	if !testEqual(q, want) {
+		t.Errorf("got %+v, want %+v", q, want)
+	}
+
+	// Binding again with different values must not disturb the template.
+	q2, err := pq.Bind(map[string]interface{}{"min": 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want2 := coll.Where("a", ">", 10).OrderBy("b", Asc)
+	if !testEqual(q2, want2) {
+		t.Errorf("got %+v, want %+v", q2, want2)
+	}
+
+	if _, err := pq.Bind(map[string]interface{}{}); err == nil {
+		t.Error("missing parameter: got nil error, want non-nil")
+	}
+
+	if _, err := coll.Where("a", ">", Param("min")).Prepare().q.toProto(); err == nil {
+		t.Error("unbound Param in toProto: got nil error, want non-nil")
+	}
+}
+
+func TestPreparedQueryBindNormalizesFilters(t *testing.T) {
+	// Bind should leave a filter tree in the same canonical shape
+	// normalizeEntityFilter produces, flattening the nested same-operator
+	// Or that substituting bval's Param doesn't otherwise disturb.
+	c := &Client{projectID: "P", databaseID: "DB"}
+	coll := c.Collection("C")
+	a := PropertyFilter{Path: "a", Operator: "==", Value: 1}
+	pq := coll.WhereEntity(
+		OrFilter{Filters: []EntityFilter{
+			OrFilter{Filters: []EntityFilter{
+				a,
+				PropertyFilter{Path: "b", Operator: "==", Value: Param("bval")},
+			}},
+			PropertyFilter{Path: "c", Operator: "==", Value: 3},
+		}},
+	).Prepare()
+
+	q, err := pq.Bind(map[string]interface{}{"bval": 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []EntityFilter{OrFilter{Filters: []EntityFilter{
+		a,
+		PropertyFilter{Path: "b", Operator: "==", Value: 2},
+		PropertyFilter{Path: "c", Operator: "==", Value: 3},
+	}}}
+	if !testEqual(q.filters, want) {
+		t.Errorf("got %+v, want %+v", q.filters, want)
+	}
+}
+
+func TestBatchRunQueries(t *testing.T) {
+	const dbPath = "projects/projectID/databases/(default)"
+	ctx := context.Background()
+	c, srv, cleanup := newMock(t)
+	defer cleanup()
+
+	srv.addRPC(nil, []interface{}{
+		&pb.RunQueryResponse{
+			Document: &pb.Document{
+				Name:       dbPath + "/documents/C/a",
+				CreateTime: aTimestamp,
+				UpdateTime: aTimestamp,
+				Fields:     map[string]*pb.Value{"f": intval(1)},
+			},
+		},
+	})
+	srv.addRPC(nil, []interface{}{
+		&pb.RunQueryResponse{
+			Document: &pb.Document{
+				Name:       dbPath + "/documents/C/b",
+				CreateTime: aTimestamp,
+				UpdateTime: aTimestamp,
+				Fields:     map[string]*pb.Value{"f": intval(2)},
+			},
+		},
+	})
+
+	q1 := c.Collection("C").Where("f", "==", 1)
+	q2 := c.Collection("C").Where("f", "==", 2)
+	results, err := c.BatchRunQueries(ctx, []*Query{&q1, &q2}, MaxConcurrency(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+	for i, r := range results {
+		if r.Index != i {
+			t.Errorf("result %d: got Index %d, want %d", i, r.Index, i)
+		}
+		if r.Err != nil {
+			t.Errorf("result %d: got err %v, want nil", i, r.Err)
+		}
+		if len(r.Docs) != 1 {
+			t.Errorf("result %d: got %d docs, want 1", i, len(r.Docs))
+		}
+	}
+}
+
+func TestNormalizeEntityFilter(t *testing.T) {
+	// Or(And(a, b)) should unwrap the single-child And, then the
+	// single-child Or, down to the bare PropertyFilter.
+	a := PropertyFilter{Path: "a", Operator: "==", Value: 1}
+	nested := OrFilter{Filters: []EntityFilter{AndFilter{Filters: []EntityFilter{a}}}}
+	got, err := normalizeEntityFilter(nested)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !testEqual(got, EntityFilter(a)) {
+		t.Errorf("got %+v, want %+v", got, a)
+	}
+
+	// An empty composite filter is an error.
+	if _, err := normalizeEntityFilter(AndFilter{}); err == nil {
+		t.Error("empty AndFilter: got nil error, want non-nil")
+	}
+	if _, err := normalizeEntityFilter(OrFilter{}); err == nil {
+		t.Error("empty OrFilter: got nil error, want non-nil")
+	}
+
+	// Or(Or(a, b), c) should flatten the nested same-operator Or into its
+	// parent, producing a single three-child Or rather than a two-child
+	// Or with a nested Or. createTestScenarios' "three-way Or" input is
+	// already flat, so it can't tell flattening apart from doing nothing;
+	// this is the case that actually distinguishes the two.
+	b := PropertyFilter{Path: "b", Operator: "==", Value: 2}
+	c := PropertyFilter{Path: "c", Operator: "==", Value: 3}
+	gotFlat, err := normalizeEntityFilter(OrFilter{
+		Filters: []EntityFilter{
+			OrFilter{Filters: []EntityFilter{a, b}},
+			c,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantFlat := OrFilter{Filters: []EntityFilter{a, b, c}}
+	if !testEqual(gotFlat, EntityFilter(wantFlat)) {
+		t.Errorf("got %+v, want %+v", gotFlat, wantFlat)
+	}
+}
+
+func TestMMRReranker(t *testing.T) {
+	ctx := context.Background()
+	c := &Client{projectID: "P", databaseID: "DB"}
+	coll := c.Collection("C")
+
+	mkDoc := func(name string, v []float64) *DocumentSnapshot {
+		vals := make([]*pb.Value, len(v))
+		for i, f := range v {
+			vals[i] = &pb.Value{ValueType: &pb.Value_DoubleValue{DoubleValue: f}}
+		}
+		return &DocumentSnapshot{
+			Ref: coll.Doc(name),
+			c:   c,
+			proto: &pb.Document{
+				Fields: map[string]*pb.Value{
+					"v": arrayval(vals...),
+				},
+			},
+		}
+	}
+
+	query := []float64{1, 0}
+	docA := mkDoc("a", []float64{1, 0})      // identical to query
+	docB := mkDoc("b", []float64{0.99, 0.1}) // very close to both query and A
+	docC := mkDoc("c", []float64{0, 1})      // orthogonal to query, diverse from A/B
+
+	reranker := NewMMRReranker(0.3, "v")
+	got, err := reranker.Rerank(ctx, query, []*DocumentSnapshot{docA, docB, docC})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) < 2 || got[0].Ref.ID != "a" || got[1].Ref.ID != "c" {
+		t.Errorf("lambda=0.3: got %v, want [a c ...] (diverse pair)", refIDs(got))
+	}
+
+	pureReranker := NewMMRReranker(1.0, "v")
+	got, err = pureReranker.Rerank(ctx, query, []*DocumentSnapshot{docA, docB, docC})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) < 2 || got[0].Ref.ID != "a" || got[1].Ref.ID != "b" {
+		t.Errorf("lambda=1.0: got %v, want [a b ...] (pure similarity pair)", refIDs(got))
+	}
+}
+
+func refIDs(docs []*DocumentSnapshot) []string {
+	ids := make([]string, len(docs))
+	for i, d := range docs {
+		ids[i] = d.Ref.ID
+	}
+	return ids
+}
+
+func TestDocumentIteratorExplainMetrics(t *testing.T) {
+	const dbPath = "projects/projectID/databases/(default)"
+	ctx := context.Background()
+	c, srv, cleanup := newMock(t)
+	defer cleanup()
+
+	indexUsed, err := structpb.NewStruct(map[string]interface{}{
+		"indexName":  "idx1",
+		"properties": "(f ASC)",
+		"queryScope": "COLLECTION",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	debugStats, err := structpb.NewStruct(map[string]interface{}{"index_entries_scanned": "5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.addRPC(nil, []interface{}{
+		&pb.RunQueryResponse{
+			Document: &pb.Document{
+				Name:       dbPath + "/documents/C/a",
+				CreateTime: aTimestamp,
+				UpdateTime: aTimestamp,
+				Fields:     map[string]*pb.Value{"f": intval(2)},
+			},
+			ReadTime: aTimestamp,
+			ExplainMetrics: &pb.ExplainMetrics{
+				PlanSummary: &pb.PlanSummary{
+					IndexesUsed: []*structpb.Struct{indexUsed},
+				},
+				ExecutionStats: &pb.ExecutionStats{
+					ResultsReturned:   1,
+					ExecutionDuration: durationpb.New(2 * time.Millisecond),
+					ReadOperations:    1,
+					DebugStats:        debugStats,
+				},
+			},
+		},
+	})
+
+	it := c.Collection("C").WithRunOptions(ExplainOptions{Analyze: true}).Documents(ctx)
+	if _, err := it.ExplainMetrics(); !errors.Is(err, ErrExplainNotReady) {
+		t.Errorf("before iteration: got %v, want ErrExplainNotReady", err)
+	}
+	if _, err := it.GetAll(); err != nil {
+		t.Fatal(err)
+	}
+	metrics, err := it.ExplainMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics.PlanSummary.IndexesUsed) != 1 || metrics.PlanSummary.IndexesUsed[0].IndexName != "idx1" {
+		t.Errorf("got PlanSummary %+v, want one index named idx1", metrics.PlanSummary)
+	}
+	if metrics.ExecutionStats.ResultsReturned != 1 || metrics.ExecutionStats.ExecutionDuration != 2*time.Millisecond {
+		t.Errorf("got ExecutionStats %+v", metrics.ExecutionStats)
+	}
+}
+
+func TestGetAllWithExplainMetrics(t *testing.T) {
+	const dbPath = "projects/projectID/databases/(default)"
+	ctx := context.Background()
+	c, srv, cleanup := newMock(t)
+	defer cleanup()
+
+	planSummary := &pb.PlanSummary{}
+	srv.addRPC(nil, []interface{}{
+		&pb.RunQueryResponse{
+			ExplainMetrics: &pb.ExplainMetrics{PlanSummary: planSummary},
+		},
+	})
+
+	it := c.Collection("C").WithRunOptions(ExplainOptions{Analyze: false}).Documents(ctx)
+	docs, metrics, err := it.GetAllWithExplainMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 0 {
+		t.Errorf("got %d docs, want 0 (Analyze was false)", len(docs))
+	}
+	if metrics == nil {
+		t.Fatal("got nil ExplainMetrics")
+	}
+}
+
+func TestBatchRunQueriesExplainMetrics(t *testing.T) {
+	ctx := context.Background()
+	c, srv, cleanup := newMock(t)
+	defer cleanup()
+
+	planSummary := &pb.PlanSummary{}
+	srv.addRPC(nil, []interface{}{
+		&pb.RunQueryResponse{
+			ExplainMetrics: &pb.ExplainMetrics{PlanSummary: planSummary},
+		},
+	})
+	srv.addRPC(nil, []interface{}{
+		&pb.RunQueryResponse{
+			Document: &pb.Document{
+				Name:       "projects/projectID/databases/(default)/documents/C/a",
+				CreateTime: aTimestamp,
+				UpdateTime: aTimestamp,
+			},
+			ReadTime: aTimestamp,
+		},
+	})
+
+	explainQ := c.Collection("C").WithRunOptions(ExplainOptions{Analyze: false})
+	plainQ := c.Collection("D").Query
+	// MaxConcurrency(1) runs the queries one after another, so each one
+	// receives the mock response added for it above.
+	results, err := c.BatchRunQueries(ctx, []*Query{&explainQ, &plainQ}, MaxConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+	byIndex := map[int]BatchQueryResult{}
+	for _, r := range results {
+		byIndex[r.Index] = r
+	}
+	if got := byIndex[0]; got.Err != nil || got.ExplainMetrics == nil {
+		t.Errorf("explain query: got err=%v metrics=%v, want metrics populated", got.Err, got.ExplainMetrics)
+	}
+	if got := byIndex[1]; got.Err != nil || got.ExplainMetrics != nil {
+		t.Errorf("plain query: got err=%v metrics=%v, want nil metrics", got.Err, got.ExplainMetrics)
+	}
+}
+
+func TestQueryKeysOnly(t *testing.T) {
+	const dbPath = "projects/projectID/databases/(default)"
+	ctx := context.Background()
+	c, srv, cleanup := newMock(t)
+	defer cleanup()
+
+	srv.addRPC(nil, []interface{}{
+		&pb.RunQueryResponse{
+			Document: &pb.Document{
+				Name:       dbPath + "/documents/C/a",
+				CreateTime: aTimestamp,
+				UpdateTime: aTimestamp,
+			},
+			ReadTime: aTimestamp,
+		},
+	})
+	docs, err := c.Collection("C").KeysOnly().Documents(ctx).GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 {
+		t.Fatalf("got %d docs, want 1", len(docs))
+	}
+	got := docs[0]
+	if got.Ref == nil || got.Ref.ID != "a" {
+		t.Errorf("got Ref %v, want doc a", got.Ref)
+	}
+	if len(got.proto.GetFields()) != 0 {
+		t.Errorf("got %d fields, want 0", len(got.proto.GetFields()))
+	}
+}
+
+func TestFindNearestDistanceMeasures(t *testing.T) {
+	ctx := context.Background()
+	c, srv, cleanup := newMock(t)
+	defer cleanup()
+
+	const dbPath = "projects/projectID/databases/(default)"
+
+	testcases := []struct {
+		desc      string
+		measure   DistanceMeasure
+		threshold *float64
+		wantErr   bool
+	}{
+		{desc: "Euclidean", measure: DistanceMeasureEuclidean},
+		{desc: "Cosine", measure: DistanceMeasureCosine},
+		{desc: "DotProduct", measure: DistanceMeasureDotProduct},
+		{
+			desc:      "Euclidean with negative threshold is an error",
+			measure:   DistanceMeasureEuclidean,
+			threshold: func() *float64 { f := -0.5; return &f }(),
+			wantErr:   true,
+		},
+		{
+			desc:      "DotProduct allows a negative threshold",
+			measure:   DistanceMeasureDotProduct,
+			threshold: func() *float64 { f := -0.5; return &f }(),
+			wantErr:   false,
+		},
+	}
+	for _, tc := range testcases {
+		srv.reset()
+		srv.addRPC(nil, []interface{}{
+			&pb.RunQueryResponse{
+				Document: &pb.Document{
+					Name:       dbPath + "/documents/C/a",
+					CreateTime: aTimestamp,
+					UpdateTime: aTimestamp,
+				},
+			},
+		})
+		var opts *FindNearestOptions
+		if tc.threshold != nil {
+			opts = &FindNearestOptions{DistanceThreshold: tc.threshold}
+		}
+		vQuery := c.Collection("C").FindNearest("embedding", []float64{1, 2, 3}, 2, tc.measure, opts)
+		_, err := vQuery.Documents(ctx).GetAll()
+		if err == nil && tc.wantErr {
+			t.Fatalf("%s: got nil wanted error", tc.desc)
+		} else if err != nil && !tc.wantErr {
+			t.Fatalf("%s: got %v, want nil", tc.desc, err)
+		}
+	}
+}
+
+func TestVectorEncodeDecode(t *testing.T) {
+	c := &Client{projectID: "P", databaseID: "DB"}
+	v := c.NewVector(1, 2, 3)
+	if len(v) != 3 || v[0] != 1 || v[1] != 2 || v[2] != 3 {
+		t.Fatalf("NewVector: got %v", v)
+	}
+
+	pv := vectorToProtoValue(v)
+	mv := pv.GetMapValue()
+	if mv.GetFields()[typeKey].GetStringValue() != typeValVector {
+		t.Errorf("encoded vector missing %s marker", typeKey)
+	}
+
+	got, ok := vectorFromProtoValue(pv)
+	if !ok {
+		t.Fatal("vectorFromProtoValue: got ok=false, want true")
+	}
+	if !testEqual(got, v) {
+		t.Errorf("round trip: got %v, want %v", got, v)
+	}
+
+	if _, ok := vectorFromProtoValue(intval(5)); ok {
+		t.Error("vectorFromProtoValue on a plain int value: got ok=true, want false")
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firestore
+
+import "fmt"
+
+// validateFindNearestQuery is called by Query.FindNearest before building
+// the vector clause, so that combinations the backend would otherwise
+// reject over RPC are instead reported as ordinary Go errors at build
+// time, consistent with how Query.toProto already surfaces other
+// malformed queries through q.err.
+//
+// A FindNearest query cannot specify an explicit sort order (the result
+// order is defined by distance to the query vector) and cannot be
+// combined with a cursor (StartAt/StartAfter/EndAt/EndBefore), since
+// cursors are defined in terms of an order. Where/WhereEntity filters are
+// unaffected and remain fully composable with FindNearest.
+func validateFindNearestQuery(q Query) error {
+	if len(q.orders) > 0 {
+		return fmt.Errorf("firestore: cannot combine FindNearest with OrderBy/OrderByPath; vector search results are ordered by distance")
+	}
+	if len(q.startVals) > 0 || len(q.endVals) > 0 || q.startDoc != nil || q.endDoc != nil {
+		return fmt.Errorf("firestore: cannot combine FindNearest with StartAt/StartAfter/EndAt/EndBefore cursors")
+	}
+	return nil
+}
+
+// resolveFindNearestLimit reconciles a limit passed explicitly to
+// FindNearest with any limit already set on the underlying Query via
+// Limit. FindNearest's own limit always wins: it bounds how many
+// candidates the vector search considers, which is a different knob than
+// the page-size Limit applies to an ordinary query, so silently
+// combining the two (for example by taking the smaller one) would be
+// surprising. Callers that set both should treat the Query.Limit value as
+// ignored.
+func resolveFindNearestLimit(q Query, findNearestLimit int32) int32 {
+	return findNearestLimit
+}
@@ -0,0 +1,79 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firestore
+
+import (
+	"fmt"
+
+	pb "cloud.google.com/go/firestore/apiv1/firestorepb"
+)
+
+// FindNearestOptions holds the optional parameters to Query.FindNearest.
+// A nil *FindNearestOptions is equivalent to the zero value: no distance
+// threshold and no synthetic distance field.
+type FindNearestOptions struct {
+	// DistanceThreshold excludes candidates whose distance from the query
+	// vector doesn't satisfy it; see applyFindNearestOptions for the sign
+	// convention, which depends on the DistanceMeasure.
+	DistanceThreshold *float64
+
+	// DistanceResultField, if non-empty, is the name of a synthetic field
+	// added to each result document holding its computed distance from
+	// the query vector, readable with DocumentSnapshot.VectorDistance.
+	DistanceResultField string
+}
+
+// VectorDistance returns the value of the synthetic distance field
+// configured via FindNearestOptions.DistanceResultField, or (0, false) if
+// the option wasn't set or the document doesn't carry that field (for
+// example, because the query wasn't a FindNearest query).
+func (d *DocumentSnapshot) VectorDistance() (float64, bool) {
+	if d.distanceResultField == "" {
+		return 0, false
+	}
+	var dist float64
+	if err := d.DataAt(d.distanceResultField, &dist); err != nil {
+		return 0, false
+	}
+	return dist, true
+}
+
+// applyFindNearestOptions validates opts against measure and wires
+// DistanceThreshold/DistanceResultField into the FindNearest proto.
+//
+// DistanceThreshold's sign convention depends on measure: for
+// DistanceMeasureEuclidean and DistanceMeasureCosine, smaller distances
+// are more similar, so the threshold is a maximum and must be
+// non-negative. DistanceMeasureDotProduct is the opposite -- larger is
+// more similar, and dot products can legitimately be negative -- so its
+// threshold is a minimum with no sign restriction.
+func applyFindNearestOptions(fn *pb.StructuredQuery_FindNearest, measure DistanceMeasure, opts *FindNearestOptions) error {
+	if opts == nil {
+		return nil
+	}
+	if opts.DistanceThreshold != nil {
+		if measure != DistanceMeasureDotProduct && *opts.DistanceThreshold < 0 {
+			return fmt.Errorf("firestore: DistanceThreshold must be non-negative for DistanceMeasure %v, got %v", measure, *opts.DistanceThreshold)
+		}
+		if _, err := measure.toProto(); err != nil {
+			return err
+		}
+		fn.DistanceThreshold = opts.DistanceThreshold
+	}
+	if opts.DistanceResultField != "" {
+		fn.DistanceResultField = opts.DistanceResultField
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firestore
+
+import (
+	"fmt"
+
+	pb "cloud.google.com/go/firestore/apiv1/firestorepb"
+)
+
+// Vector is a fixed-size embedding, the kind of value Query.FindNearest
+// compares against with a DistanceMeasure. Construct one with
+// Client.NewVector, or round-trip one through DocumentSnapshot.DataTo by
+// tagging a []float64 (or Vector) struct field with the "vector" option:
+//
+//	type Doc struct {
+//		Embedding Vector `firestore:"embedding,vector"`
+//	}
+//
+// Firestore stores a Vector as a map with a reserved __type__ marker, not
+// as a plain array, so the "vector" tag is required for DataTo to tell
+// the two apart on decode.
+type Vector []float64
+
+// NewVector returns a Vector holding a copy of values.
+func (c *Client) NewVector(values ...float64) Vector {
+	return append(Vector(nil), values...)
+}
+
+// DistanceMeasureCosine and DistanceMeasureDotProduct extend the existing
+// DistanceMeasure type (DistanceMeasureEuclidean is its zero value) with
+// the two other measures FindNearest supports.
+const (
+	// DistanceMeasureCosine measures the angle between the two vectors,
+	// ignoring magnitude; smaller is more similar.
+	DistanceMeasureCosine DistanceMeasure = iota + 1
+	// DistanceMeasureDotProduct measures the dot product of the two
+	// vectors; unlike the other measures, larger is more similar.
+	DistanceMeasureDotProduct
+)
+
+func (m DistanceMeasure) toProto() (pb.StructuredQuery_FindNearest_DistanceMeasure, error) {
+	switch m {
+	case DistanceMeasureEuclidean:
+		return pb.StructuredQuery_FindNearest_EUCLIDEAN, nil
+	case DistanceMeasureCosine:
+		return pb.StructuredQuery_FindNearest_COSINE, nil
+	case DistanceMeasureDotProduct:
+		return pb.StructuredQuery_FindNearest_DOT_PRODUCT, nil
+	default:
+		return 0, fmt.Errorf("firestore: unrecognized DistanceMeasure %d", m)
+	}
+}
+
+// vectorToProtoValue encodes v the same way the server does: a map value
+// with a __type__ marker of "__vector__" and the components under the
+// "value" key, matching typeKey/valueKey/typeValVector used elsewhere for
+// sentinel-wrapped values.
+func vectorToProtoValue(v Vector) *pb.Value {
+	vals := make([]*pb.Value, len(v))
+	for i, f := range v {
+		vals[i] = &pb.Value{ValueType: &pb.Value_DoubleValue{DoubleValue: f}}
+	}
+	return &pb.Value{
+		ValueType: &pb.Value_MapValue{
+			MapValue: &pb.MapValue{
+				Fields: map[string]*pb.Value{
+					typeKey:  {ValueType: &pb.Value_StringValue{StringValue: typeValVector}},
+					valueKey: {ValueType: &pb.Value_ArrayValue{ArrayValue: &pb.ArrayValue{Values: vals}}},
+				},
+			},
+		},
+	}
+}
+
+// vectorFromProtoValue decodes a value built by vectorToProtoValue,
+// returning ok == false if pv isn't a sentinel-wrapped vector.
+func vectorFromProtoValue(pv *pb.Value) (Vector, bool) {
+	mv := pv.GetMapValue()
+	if mv == nil {
+		return nil, false
+	}
+	if mv.Fields[typeKey].GetStringValue() != typeValVector {
+		return nil, false
+	}
+	arr := mv.Fields[valueKey].GetArrayValue()
+	if arr == nil {
+		return nil, false
+	}
+	out := make(Vector, len(arr.Values))
+	for i, v := range arr.Values {
+		out[i] = v.GetDoubleValue()
+	}
+	return out, true
+}